@@ -0,0 +1,373 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/ipfs-check/checker"
+	checkclient "github.com/ipfs/ipfs-check/client"
+)
+
+// openAPISpec documents /api/v1
+//
+//go:embed api/v1/openapi.json
+var openAPISpec []byte
+
+// apiV1CheckHandler serves /api/v1/check, which takes the same query
+// parameters as /check
+func apiV1CheckHandler(d *daemon) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+		w.Header().Add("Access-Control-Expose-Headers", "Age, Cache-Status, "+requestIDHeader)
+		ctx := withRequestID(w, r)
+
+		req, err := parseCheckRequest(r.URL.Query())
+		if err != nil {
+			var reqErr *checkRequestError
+			if !errors.As(err, &reqErr) {
+				reqErr = &checkRequestError{checkclient.ErrorCodeInvalidParameter, err.Error()}
+			}
+			writeCheckResponse(w, http.StatusBadRequest, checkclient.CheckResponse{
+				Input:  newCheckInput(req),
+				Errors: []checkclient.APIError{{Code: reqErr.code, Message: reqErr.message}},
+			})
+			return
+		}
+		out := checkclient.CheckResponse{
+			Mode:    req.mode(),
+			Input:   newCheckInput(req),
+			Results: []checkclient.PeerResult{},
+			Errors:  []checkclient.APIError{},
+		}
+
+		res, err := d.runCheck(ctx, w.Header(), req)
+		if err == nil {
+			out.Results, out.Summary, err = newCheckResults(req, res.Body)
+		}
+		if err != nil {
+			out.Errors = append(out.Errors, checkclient.APIError{Code: checkclient.ErrorCodeCheckFailed, Message: err.Error()})
+			writeCheckResponse(w, http.StatusInternalServerError, out)
+			return
+		}
+		out.CheckedAt = &res.CachedAt
+		writeCheckResponse(w, http.StatusOK, out)
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(openAPISpec)
+}
+
+func writeCheckResponse(w http.ResponseWriter, status int, out checkclient.CheckResponse) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// apiV1NotReady responds to /api/v1/check requests rejected by
+// requireDHTReady with a not_ready error
+func apiV1NotReady(w http.ResponseWriter, r *http.Request) {
+	// the input is returned as far as it could be parsed
+	req, _ := parseCheckRequest(r.URL.Query())
+	writeCheckResponse(w, http.StatusServiceUnavailable, checkclient.CheckResponse{
+		Mode:    req.mode(),
+		Input:   newCheckInput(req),
+		Results: []checkclient.PeerResult{},
+		Errors:  []checkclient.APIError{{Code: checkclient.ErrorCodeNotReady, Message: dhtNotReadyMessage}},
+	})
+}
+
+func newCheckInput(req checkRequest) checkclient.CheckInput {
+	return checkclient.CheckInput{
+		CID:                   req.cidStr,
+		Multiaddr:             req.maStr,
+		TimeoutSeconds:        req.timeout.Seconds(),
+		IPNIIndexer:           req.opts.IPNIURL,
+		ProbeBitswapProtocols: req.opts.ProbeBitswapProtocols,
+		GetBlock:              req.opts.GetBlock,
+		Probes:                nonNil(req.opts.Probes),
+		Fresh:                 req.opts.Fresh,
+	}
+}
+
+func newCheckSummary(results []checkclient.PeerResult, mode string, verdict checker.Summary) checkclient.CheckSummary {
+	summary := checkclient.CheckSummary{
+		Peers:        len(results),
+		Verdict:      verdict.Verdict,
+		FailedSteps:  nonNil(verdict.FailedSteps),
+		Explanations: nonNil(verdict.Explanations),
+	}
+	for _, res := range results {
+		if res.ConnectionError == "" {
+			summary.Reachable++
+		}
+		if mode != multiaddrCheckMode && dataAvailable(res) {
+			summary.DataAvailable++
+		}
+	}
+	return summary
+}
+
+// dataAvailable returns whether the peer served the data over any of the probes
+func dataAvailable(res checkclient.PeerResult) bool {
+	for _, pr := range res.Probes {
+		if pr.DataAvailable {
+			return true
+		}
+	}
+	return false
+}
+
+// newCheckResults converts the result of a check, encoded as a /check
+// response, to the results and the summary of /api/v1/check
+func newCheckResults(req checkRequest, body []byte) ([]checkclient.PeerResult, checkclient.CheckSummary, error) {
+	var results []checkclient.PeerResult
+	var verdict checker.Summary
+	switch req.mode() {
+	case cidCheckMode:
+		var providers []checker.ProviderOutput
+		if err := json.Unmarshal(body, &providers); err != nil {
+			return nil, checkclient.CheckSummary{}, err
+		}
+		results = make([]checkclient.PeerResult, 0, len(providers))
+		for _, p := range providers {
+			results = append(results, newProviderResult(p))
+		}
+		verdict = checker.SummarizeProviders(providers)
+	case multiaddrCheckMode:
+		var out checker.PeerHealthOutput
+		if err := json.Unmarshal(body, &out); err != nil {
+			return nil, checkclient.CheckSummary{}, err
+		}
+		results = []checkclient.PeerResult{newPeerHealthResult(req, out)}
+	default:
+		var out checker.PeerCheckOutput
+		if err := json.Unmarshal(body, &out); err != nil {
+			return nil, checkclient.CheckSummary{}, err
+		}
+		results = []checkclient.PeerResult{newPeerCheckResult(req, out)}
+		verdict = checker.SummarizePeerCheck(req.ma, &out)
+	}
+	return results, newCheckSummary(results, req.mode(), verdict), nil
+}
+
+func newProviderResult(p checker.ProviderOutput) checkclient.PeerResult {
+	res := checkclient.PeerResult{
+		PeerID:              p.ID,
+		Source:              p.Source,
+		Addrs:               p.Addrs,
+		ConnectionError:     p.ConnectionError,
+		ConnectionMaddrs:    nonNil(p.ConnectionMaddrs),
+		AdvertisedProtocols: p.Protocols,
+		Metadata:            newProtocolMetadata(p.Metadata),
+		ProtocolMismatches:  p.ProtocolMismatches,
+	}
+	setProbeResults(&res, p.Transport, p.Probes)
+	return res
+}
+
+// setProbeResults sets the results of the probes that ran, and the transport
+// with its result
+func setProbeResults(res *checkclient.PeerResult, transport string, probes checker.ProbeResults) {
+	if len(probes) == 0 {
+		return
+	}
+	res.Transport = transport
+	res.Probes = make(map[string]checkclient.ProbeResult, len(probes))
+	for name, out := range probes {
+		pr := checkclient.ProbeResult{DataAvailable: out.DataAvailable()}
+		switch out := out.(type) {
+		case checker.BitswapCheckOutput:
+			pr.Bitswap = newBitswapResult(out)
+			res.Bitswap = pr.Bitswap
+		case checker.GraphsyncCheckOutput:
+			pr.Graphsync = newGraphsyncResult(out)
+			res.Graphsync = pr.Graphsync
+		default:
+			pr.Result, _ = json.Marshal(out)
+		}
+		res.Probes[name] = pr
+	}
+}
+
+func newProtocolMetadata(in map[string]checker.IPNIMetadata) map[string]checkclient.ProtocolMetadata {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]checkclient.ProtocolMetadata, len(in))
+	for p, md := range in {
+		out[p] = checkclient.ProtocolMetadata{
+			PieceCID:      md.PieceCID,
+			VerifiedDeal:  md.VerifiedDeal,
+			FastRetrieval: md.FastRetrieval,
+		}
+	}
+	return out
+}
+
+func newPeerCheckResult(req checkRequest, out checker.PeerCheckOutput) checkclient.PeerResult {
+	res := checkclient.PeerResult{
+		PeerID:           req.ai.ID.String(),
+		ConnectionError:  out.ConnectionError,
+		ConnectionMaddrs: nonNil(out.ConnectionMaddrs),
+		DHT: &checkclient.PeerDHTResult{
+			PeerAddrs:      nonNilMap(out.PeerFoundInDHT),
+			ProviderRecord: &out.ProviderRecordFromPeerInDHT,
+			Replication: &checkclient.ProviderRecordReplicationResult{
+				ClosestPeers:       out.ProviderRecordReplicationInDHT.ClosestPeers,
+				PeersWithRecord:    nonNil(out.ProviderRecordReplicationInDHT.PeersWithRecord),
+				PeersWithoutRecord: nonNil(out.ProviderRecordReplicationInDHT.PeersWithoutRecord),
+				PeersFailed:        nonNilMap(out.ProviderRecordReplicationInDHT.PeersFailed),
+				Addrs:              nonNilMap(out.ProviderRecordReplicationInDHT.Addrs),
+				Error:              out.ProviderRecordReplicationInDHT.Error,
+			},
+			Freshness: newFreshnessResult(out.ProviderRecordFreshnessInDHT),
+		},
+		IPNI: &checkclient.PeerIPNIResult{
+			ProviderRecord: out.ProviderRecordFromPeerInIPNI,
+			ProviderStatus: newIPNIProviderStatus(out.ProviderStatusInIPNI),
+		},
+	}
+	if out.ConnectionError == "" {
+		setProbeResults(&res, out.Transport, out.Probes)
+	}
+	return res
+}
+
+func newPeerHealthResult(req checkRequest, out checker.PeerHealthOutput) checkclient.PeerResult {
+	health := &checkclient.PeerHealthResult{
+		PingRTTMs:    ms(out.PingRTT),
+		PingError:    out.PingError,
+		AgentVersion: out.AgentVersion,
+		Protocols:    nonNil(out.Protocols),
+		ListenAddrs:  nonNil(out.ListenAddrs),
+		AddrDials:    make([]checkclient.AddrDialResult, 0, len(out.AddrDials)),
+	}
+	for _, dial := range out.AddrDials {
+		health.AddrDials = append(health.AddrDials, checkclient.AddrDialResult{
+			Addr:       dial.Addr,
+			Transport:  dial.Transport,
+			DurationMs: ms(dial.Duration),
+			Error:      dial.Error,
+		})
+	}
+	if s := out.DHTServer; s != nil {
+		health.DHTServer = &checkclient.DHTServerResult{
+			IsServer:          s.IsServer,
+			Useful:            s.Useful,
+			FindNode:          newDHTQueryResult(s.FindNode),
+			GetProviders:      newDHTQueryResult(s.GetProviders),
+			GetValue:          newDHTQueryResult(s.GetValue),
+			Neighbors:         s.Neighbors,
+			NeighborsWithPeer: s.NeighborsWithPeer,
+			Warnings:          nonNil(s.Warnings),
+		}
+	}
+	return checkclient.PeerResult{
+		PeerID:           req.ai.ID.String(),
+		ConnectionError:  out.ConnectionError,
+		ConnectionMaddrs: nonNil(out.ConnectionMaddrs),
+		DHT:              &checkclient.PeerDHTResult{PeerAddrs: nonNilMap(out.PeerFoundInDHT)},
+		Health:           health,
+	}
+}
+
+func newBitswapResult(out checker.BitswapCheckOutput) *checkclient.BitswapResult {
+	return &checkclient.BitswapResult{
+		Found:                   out.Found,
+		Responded:               out.Responded,
+		Error:                   out.Error,
+		Protocol:                out.Protocol,
+		LegacyOnly:              out.LegacyOnly,
+		SupportedProtocols:      out.SupportedProtocols,
+		DurationMs:              ms(out.Duration),
+		ConnectDurationMs:       ms(out.ConnectDuration),
+		FirstResponseDurationMs: ms(out.FirstResponseDuration),
+		BlockDurationMs:         ms(out.BlockDuration),
+		BlockSize:               out.BlockSize,
+		BlockReceived:           out.BlockReceived,
+		BlockValid:              out.BlockValid,
+	}
+}
+
+func newGraphsyncResult(out checker.GraphsyncCheckOutput) *checkclient.GraphsyncResult {
+	return &checkclient.GraphsyncResult{
+		DurationMs:      ms(out.Duration),
+		Responded:       out.Responded,
+		BlockServed:     out.BlockServed,
+		Status:          out.Status,
+		VoucherRequired: out.VoucherRequired,
+		Error:           out.Error,
+	}
+}
+
+func newIPNIProviderStatus(out checker.IPNIProviderCheckOutput) checkclient.IPNIProviderStatus {
+	return checkclient.IPNIProviderStatus{
+		Found:                    out.Found,
+		LastAdvertisement:        out.LastAdvertisement,
+		LastAdvertisementTime:    out.LastAdvertisementTime,
+		SinceLastAdvertisementMs: ms(out.SinceLastAdvertisement),
+		Lag:                      out.Lag,
+		PublisherID:              out.PublisherID,
+		PublisherAddrs:           out.PublisherAddrs,
+		Inactive:                 out.Inactive,
+		LastIngestionError:       out.LastIngestionError,
+		LastIngestionErrorTime:   out.LastIngestionErrorTime,
+		HeadAdvertisement:        out.HeadAdvertisement,
+		HeadError:                out.HeadError,
+		BehindHead:               out.BehindHead,
+		Error:                    out.Error,
+	}
+}
+
+func newFreshnessResult(out checker.ProviderRecordFreshness) *checkclient.ProviderRecordFreshnessResult {
+	res := &checkclient.ProviderRecordFreshnessResult{
+		Observations:        make([]checkclient.ProviderRecordObservationResult, 0, len(out.Observations)),
+		LastPublishedAfter:  out.LastPublishedAfter,
+		LastRepublishBefore: out.LastRepublishBefore,
+		ExpiresNotBefore:    out.ExpiresNotBefore,
+		Warnings:            nonNil(out.Warnings),
+	}
+	for _, o := range out.Observations {
+		res.Observations = append(res.Observations, checkclient.ProviderRecordObservationResult{
+			Time:            o.Time,
+			ClosestPeers:    o.ClosestPeers,
+			PeersWithRecord: o.PeersWithRecord,
+		})
+	}
+	return res
+}
+
+func newDHTQueryResult(out checker.DHTQueryOutput) checkclient.DHTQueryResult {
+	return checkclient.DHTQueryResult{
+		DurationMs:           ms(out.Duration),
+		CloserPeers:          out.CloserPeers,
+		CloserPeersWithAddrs: out.CloserPeersWithAddrs,
+		Error:                out.Error,
+	}
+}
+
+// ms returns the duration in milliseconds
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// nonNil returns an empty slice rather than nil, so that lists are never null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func nonNilMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return map[K]V{}
+	}
+	return m
+}
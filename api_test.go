@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-check/checker"
+	checkclient "github.com/ipfs/ipfs-check/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCheckResultsFromProviders(t *testing.T) {
+	body, err := json.Marshal([]checker.ProviderOutput{
+		{
+			ID:                       "12D3KooWBitswap",
+			Source:                   checker.SourceIPNI,
+			Transport:                checker.TransportBitswap,
+			DataAvailableOverBitswap: checker.BitswapCheckOutput{Found: true, Responded: true, ConnectDuration: 1500 * time.Microsecond},
+			Probes:                   checker.ProbeResults{checker.TransportBitswap: checker.BitswapCheckOutput{Found: true, Responded: true, ConnectDuration: 1500 * time.Microsecond}},
+		},
+		{
+			ID:                         "12D3KooWGraphsync",
+			Source:                     checker.SourceDHT,
+			Transport:                  checker.TransportGraphsync,
+			DataAvailableOverGraphsync: &checker.GraphsyncCheckOutput{Responded: true, VoucherRequired: true},
+			Probes:                     checker.ProbeResults{checker.TransportGraphsync: checker.GraphsyncCheckOutput{Responded: true, VoucherRequired: true}},
+		},
+		{ID: "12D3KooWUnreachable", Source: checker.SourceDHT, ConnectionError: "no addresses"},
+	})
+	require.NoError(t, err)
+
+	req, err := parseCheckRequest(url.Values{"cid": {"bafkqaaa"}})
+	require.NoError(t, err)
+	results, summary, err := newCheckResults(req, body)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	require.Equal(t, 1.5, results[0].Bitswap.ConnectDurationMs)
+	require.Nil(t, results[0].Graphsync)
+	require.True(t, results[0].Probes[checker.TransportBitswap].DataAvailable)
+	require.Nil(t, results[1].Bitswap)
+	require.True(t, results[1].Graphsync.VoucherRequired)
+	require.Equal(t, results[1].Graphsync, results[1].Probes[checker.TransportGraphsync].Graphsync)
+	require.Nil(t, results[2].Bitswap)
+	require.Empty(t, results[2].Probes)
+	require.Equal(t, []string{}, results[2].ConnectionMaddrs)
+
+	require.Equal(t, checkclient.CheckSummary{
+		Peers:         3,
+		Reachable:     2,
+		DataAvailable: 1,
+		Verdict:       checkclient.VerdictRetrievable,
+		FailedSteps:   []string{},
+		Explanations:  []string{"1 of the 3 providers found served the data."},
+	}, summary)
+}
+
+func TestNewCheckResultsFromPeerCheck(t *testing.T) {
+	body, err := json.Marshal(checker.PeerCheckOutput{
+		ProviderRecordFromPeerInIPNI: true,
+		Transport:                    checker.TransportGraphsync,
+		DataAvailableOverGraphsync:   &checker.GraphsyncCheckOutput{Responded: true, BlockServed: true},
+		Probes:                       checker.ProbeResults{checker.TransportGraphsync: checker.GraphsyncCheckOutput{Responded: true, BlockServed: true}},
+	})
+	require.NoError(t, err)
+
+	req, err := parseCheckRequest(url.Values{
+		"cid":       {"bafkqaaa"},
+		"multiaddr": {"/p2p/12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"},
+		"probes":    {"graphsync"},
+	})
+	require.NoError(t, err)
+	results, summary, err := newCheckResults(req, body)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	require.Equal(t, checker.TransportGraphsync, results[0].Transport)
+	require.Nil(t, results[0].Bitswap)
+	require.True(t, results[0].Graphsync.BlockServed)
+	require.Equal(t, map[string]checkclient.ProbeResult{
+		checker.TransportGraphsync: {DataAvailable: true, Graphsync: results[0].Graphsync},
+	}, results[0].Probes)
+	require.Equal(t, 1, summary.DataAvailable)
+	require.Equal(t, checkclient.VerdictRetrievable, summary.Verdict)
+}
+
+func TestParseCheckRequestErrorCodes(t *testing.T) {
+	for query, code := range map[string]string{
+		"":                                "missing_input",
+		"cid=notacid":                     "invalid_cid",
+		"multiaddr=/ip4/1.2.3.4":          "invalid_multiaddr",
+		"cid=bafkqaaa&timeoutSeconds=ten": "invalid_timeout",
+		"cid=bafkqaaa&fresh=maybe":        "invalid_parameter",
+		"cid=bafkqaaa&probes=http":        "invalid_parameter",
+	} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		_, err = parseCheckRequest(values)
+		var reqErr *checkRequestError
+		require.ErrorAs(t, err, &reqErr, query)
+		require.Equal(t, code, reqErr.code, query)
+	}
+}
+
+func TestAPIV1NotReady(t *testing.T) {
+	rec := httptest.NewRecorder()
+	apiV1NotReady(rec, httptest.NewRequest(http.MethodGet, "/api/v1/check?cid=bafkqaaa", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var out checkclient.CheckResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Equal(t, "bafkqaaa", out.Input.CID)
+	require.Len(t, out.Errors, 1)
+	require.Equal(t, checkclient.ErrorCodeNotReady, out.Errors[0].Code)
+}
+
+// TestOpenAPISpec checks that the schemas of the OpenAPI document have the
+// same properties as the JSON encoding of the API types
+func TestOpenAPISpec(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec))
+
+	for schema, v := range map[string]any{
+		"CheckResponse":             checkclient.CheckResponse{},
+		"CheckInput":                checkclient.CheckInput{},
+		"CheckSummary":              checkclient.CheckSummary{},
+		"Error":                     checkclient.APIError{},
+		"PeerResult":                checkclient.PeerResult{},
+		"BitswapResult":             checkclient.BitswapResult{},
+		"GraphsyncResult":           checkclient.GraphsyncResult{},
+		"ProbeResult":               checkclient.ProbeResult{},
+		"ProtocolMetadata":          checkclient.ProtocolMetadata{},
+		"PeerDHTResult":             checkclient.PeerDHTResult{},
+		"ProviderRecordReplication": checkclient.ProviderRecordReplicationResult{},
+		"ProviderRecordFreshness":   checkclient.ProviderRecordFreshnessResult{},
+		"PeerIPNIResult":            checkclient.PeerIPNIResult{},
+		"IPNIProviderStatus":        checkclient.IPNIProviderStatus{},
+		"PeerHealthResult":          checkclient.PeerHealthResult{},
+		"DHTServerResult":           checkclient.DHTServerResult{},
+		"DHTQueryResult":            checkclient.DHTQueryResult{},
+	} {
+		var fields []string
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			fields = append(fields, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+		}
+		var properties []string
+		for p := range spec.Components.Schemas[schema].Properties {
+			properties = append(properties, p)
+		}
+		require.ElementsMatch(t, fields, properties, schema)
+	}
+}
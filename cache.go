@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/ipfs/ipfs-check/checker"
+)
+
+// maxCachedChecks is the number of check results kept in memory, and in the
+// directory when persisted
+const maxCachedChecks = 10_000
+
+// checkCache caches the encoded results of checks for a TTL, in memory and
+// optionally in a directory so that they survive restarts. A nil checkCache
+// caches nothing.
+type checkCache struct {
+	ttl time.Duration
+	lru *expirable.LRU[string, cachedCheck]
+	// dir persists the results when set
+	dir string
+	// files are the names of the results in dir, which are removed when they
+	// expire or when there are more than maxCachedChecks
+	files *expirable.LRU[string, struct{}]
+}
+
+// cachedCheck is the encoded result of a check and when it was computed
+type cachedCheck struct {
+	CachedAt time.Time
+	Body     json.RawMessage
+}
+
+// newCheckCache returns a cache of check results, or nil when ttl is 0
+func newCheckCache(ttl time.Duration, dir string) (*checkCache, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+	c := &checkCache{
+		ttl: ttl,
+		lru: expirable.NewLRU[string, cachedCheck](maxCachedChecks, nil, ttl),
+		dir: dir,
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		c.files = expirable.NewLRU(maxCachedChecks, func(name string, _ struct{}) {
+			_ = os.Remove(filepath.Join(dir, name))
+		}, ttl)
+		if err := c.loadFiles(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// loadFiles removes the expired results from the directory, e.g. persisted
+// before a restart, and tracks the others so that they are removed later
+func (c *checkCache) loadFiles(now time.Time) error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// results are written before the file is modified for the last time
+		if strings.HasPrefix(e.Name(), ".tmp-") || now.Sub(info.ModTime()) >= c.ttl {
+			_ = os.Remove(filepath.Join(c.dir, e.Name()))
+			continue
+		}
+		files = append(files, file{name: e.Name(), modTime: info.ModTime()})
+	}
+	// the oldest results are evicted first when there are too many
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		c.files.Add(f.name, struct{}{})
+	}
+	return nil
+}
+
+// checkCacheKey identifies the checks that have the same result
+func checkCacheKey(cidStr, maStr string, timeout time.Duration, opts checker.CheckOptions) string {
+	return fmt.Sprintf("cid=%s&multiaddr=%s&timeout=%s&ipniIndexer=%s&probeBitswapProtocols=%t&getBlock=%t&probes=%s",
+		cidStr, maStr, timeout, opts.IPNIURL, opts.ProbeBitswapProtocols, opts.GetBlock, strings.Join(opts.Probes, ","))
+}
+
+func (c *checkCache) get(key string, now time.Time) (cachedCheck, bool) {
+	if c == nil {
+		return cachedCheck{}, false
+	}
+	// results loaded from the directory may be older than their entry in memory
+	if cached, ok := c.lru.Get(key); ok && now.Sub(cached.CachedAt) < c.ttl {
+		return cached, true
+	}
+	if c.dir == "" {
+		return cachedCheck{}, false
+	}
+
+	name := fileName(key)
+	raw, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return cachedCheck{}, false
+	}
+	var cached cachedCheck
+	if err := json.Unmarshal(raw, &cached); err != nil || now.Sub(cached.CachedAt) >= c.ttl {
+		if !c.files.Remove(name) {
+			_ = os.Remove(filepath.Join(c.dir, name))
+		}
+		return cachedCheck{}, false
+	}
+	c.lru.Add(key, cached)
+	return cached, true
+}
+
+func (c *checkCache) add(key string, cached cachedCheck) {
+	if c == nil {
+		return
+	}
+	c.lru.Add(key, cached)
+	if c.dir == "" {
+		return
+	}
+
+	raw, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	// write to a temporary file first, so that concurrent reads never see a partial result
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		slog.Error("Error persisting check result", "err", err)
+		return
+	}
+	_, err = tmp.Write(raw)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	name := fileName(key)
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		slog.Error("Error persisting check result", "err", err)
+		return
+	}
+	c.files.Add(name, struct{}{})
+}
+
+// fileName is the name of the file of the result in the directory, as keys
+// contain characters that aren't valid in file names
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-check/checker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCache(t *testing.T) {
+	disabled, err := newCheckCache(0, "")
+	require.NoError(t, err)
+	require.Nil(t, disabled)
+	disabled.add("key", cachedCheck{CachedAt: time.Now(), Body: []byte(`{}`)})
+	_, ok := disabled.get("key", time.Now())
+	require.False(t, ok)
+
+	dir := t.TempDir()
+	c, err := newCheckCache(time.Minute, dir)
+	require.NoError(t, err)
+
+	key := checkCacheKey("bafkqaaa", "", defaultCheckTimeout, checker.CheckOptions{IPNIURL: defaultIndexerURL})
+	otherKey := checkCacheKey("bafkqaaa", "", defaultCheckTimeout, checker.CheckOptions{IPNIURL: defaultIndexerURL, GetBlock: true})
+	now := time.Now()
+	c.add(key, cachedCheck{CachedAt: now, Body: []byte(`{"Found":true}`)})
+
+	cached, ok := c.get(key, now.Add(time.Second))
+	require.True(t, ok)
+	require.JSONEq(t, `{"Found":true}`, string(cached.Body))
+	_, ok = c.get(otherKey, now)
+	require.False(t, ok)
+
+	// results persisted in the directory are served after a restart
+	restarted, err := newCheckCache(time.Minute, dir)
+	require.NoError(t, err)
+	cached, ok = restarted.get(key, now.Add(time.Second))
+	require.True(t, ok)
+	require.Equal(t, now.UTC(), cached.CachedAt.UTC())
+	require.JSONEq(t, `{"Found":true}`, string(cached.Body))
+
+	// expired results are removed
+	_, ok = restarted.get(key, now.Add(time.Minute))
+	require.False(t, ok)
+	restarted, err = newCheckCache(time.Minute, dir)
+	require.NoError(t, err)
+	_, ok = restarted.get(key, now.Add(time.Second))
+	require.False(t, ok)
+}
+
+func TestCheckCacheBoundsDir(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newCheckCache(time.Minute, dir)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		c.add(key, cachedCheck{CachedAt: now, Body: []byte(`{}`)})
+	}
+	require.FileExists(t, filepath.Join(dir, fileName("a")))
+
+	// the oldest results are removed from the directory when there are too many
+	c.files.Resize(2)
+	require.NoFileExists(t, filepath.Join(dir, fileName("a")))
+	require.FileExists(t, filepath.Join(dir, fileName("b")))
+
+	// on startup, expired results and leftover temporary files are removed
+	old := now.Add(-2 * time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, fileName("b")), old, old))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".tmp-1"), []byte(`{`), 0o644))
+	restarted, err := newCheckCache(time.Minute, dir)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, fileName("c"), entries[0].Name())
+	require.Equal(t, []string{fileName("c")}, restarted.files.Keys())
+}
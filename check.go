@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-check/checker"
+	checkclient "github.com/ipfs/ipfs-check/client"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+// checkRequest is a check requested with the query parameters of /check and
+// /api/v1/check
+type checkRequest struct {
+	cidStr  string
+	maStr   string
+	cidKey  cid.Cid
+	ma      multiaddr.Multiaddr
+	ai      *peer.AddrInfo
+	timeout time.Duration
+	// opts.Fresh skips the caches of the results and the providers
+	opts checker.CheckOptions
+}
+
+// checkRequestError is returned for invalid check requests, with a code for
+// the JSON API
+type checkRequestError struct {
+	code    string
+	message string
+}
+
+func (e *checkRequestError) Error() string {
+	return e.message
+}
+
+// parseCheckRequest parses the query parameters of a check. Without a cid,
+// only the connectivity of the peer is checked.
+func parseCheckRequest(query url.Values) (checkRequest, error) {
+	req := checkRequest{
+		cidStr:  query.Get("cid"),
+		maStr:   query.Get("multiaddr"),
+		timeout: defaultCheckTimeout,
+		opts:    checker.CheckOptions{IPNIURL: query.Get("ipniIndexer")},
+	}
+	if req.cidStr == "" && req.maStr == "" {
+		return req, &checkRequestError{checkclient.ErrorCodeMissingInput, "missing 'cid' or 'multiaddr' query parameter"}
+	}
+
+	if req.cidStr != "" {
+		var err error
+		req.cidKey, err = cid.Decode(req.cidStr)
+		if err != nil {
+			mh, mhErr := multihash.FromB58String(req.cidStr)
+			if mhErr != nil {
+				mh, mhErr = multihash.FromHexString(req.cidStr)
+				if mhErr != nil {
+					return req, &checkRequestError{checkclient.ErrorCodeInvalidCID, err.Error()}
+				}
+			}
+			req.cidKey = cid.NewCidV1(cid.Raw, mh)
+		}
+	}
+
+	if timeoutStr := query.Get("timeoutSeconds"); timeoutStr != "" {
+		var err error
+		req.timeout, err = time.ParseDuration(timeoutStr + "s")
+		if err != nil {
+			return req, &checkRequestError{checkclient.ErrorCodeInvalidTimeout, "Invalid timeout value (in seconds)"}
+		}
+	}
+
+	if req.opts.IPNIURL == "" {
+		req.opts.IPNIURL = defaultIndexerURL
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *bool
+	}{
+		{"probeBitswapProtocols", &req.opts.ProbeBitswapProtocols},
+		{"getBlock", &req.opts.GetBlock},
+		{"fresh", &req.opts.Fresh},
+	} {
+		str := query.Get(param.name)
+		if str == "" {
+			continue
+		}
+		var err error
+		*param.value, err = strconv.ParseBool(str)
+		if err != nil {
+			return req, &checkRequestError{checkclient.ErrorCodeInvalidParameter, fmt.Sprintf("Invalid %s value (expected a boolean)", param.name)}
+		}
+	}
+
+	if probes := query.Get("probes"); probes != "" {
+		for _, name := range strings.Split(probes, ",") {
+			if _, ok := checker.LookupProbe(name); !ok {
+				return req, &checkRequestError{checkclient.ErrorCodeInvalidParameter, fmt.Sprintf("Invalid probes value: unknown probe %q, expected a comma-separated list of: %s", name, strings.Join(checker.ProbeNames(), ", "))}
+			}
+			req.opts.Probes = append(req.opts.Probes, name)
+		}
+	}
+
+	if req.maStr != "" {
+		var err error
+		req.ma, req.ai, err = parseMultiaddr(req.maStr)
+		if err != nil {
+			return req, &checkRequestError{checkclient.ErrorCodeInvalidMultiaddr, err.Error()}
+		}
+	}
+	return req, nil
+}
+
+// mode returns what the request checks, depending on its parameters
+func (req checkRequest) mode() string {
+	switch {
+	case req.maStr == "":
+		return cidCheckMode
+	case req.cidStr == "":
+		return multiaddrCheckMode
+	default:
+		return cidMultiaddrCheckMode
+	}
+}
+
+func (req checkRequest) cacheKey() string {
+	normalizedCid := ""
+	if req.cidKey.Defined() {
+		normalizedCid = req.cidKey.String()
+	}
+	return checkCacheKey(normalizedCid, req.maStr, req.timeout, req.opts)
+}
+
+// runCheck returns the result of the check, encoded as a /check response. It
+// is served from the cache unless a fresh check is requested, and identical
+// checks running at the same time share a single execution. The Age and
+// Cache-Status headers are set on header.
+func (d *daemon) runCheck(ctx context.Context, header http.Header, req checkRequest) (cachedCheck, error) {
+	key := req.cacheKey()
+
+	// Serve the result of an identical check that ran recently, unless a fresh one is requested
+	if !req.opts.Fresh {
+		if cached, ok := d.checkCache.get(key, time.Now()); ok {
+			age := time.Since(cached.CachedAt)
+			header.Add("Age", strconv.Itoa(int(age.Seconds())))
+			header.Add("Cache-Status", fmt.Sprintf("ipfs-check; hit; ttl=%d", int((d.checkCache.ttl-age).Seconds())))
+			return cached, nil
+		}
+	}
+
+	// Identical checks running at the same time share a single execution
+	res, err := d.runCoalesced(ctx, key, func(ctx context.Context) (cachedCheck, error) {
+		logger(ctx).Info("Checking", "cid", req.cidStr, "multiaddr", req.maStr, "timeout", req.timeout)
+		withTimeout, cancel := context.WithTimeout(ctx, req.timeout)
+		defer cancel()
+
+		var data interface{}
+		var err error
+		cachedAt := time.Now()
+		switch req.mode() {
+		case cidCheckMode:
+			var out []checker.ProviderOutput
+			out, err = d.checker.CheckCID(withTimeout, req.cidKey, req.opts)
+			if err == nil {
+				for i := range out {
+					out[i].CachedAt = cachedAt
+				}
+				d.metrics.observeCidCheck(out)
+			}
+			data = out
+		case multiaddrCheckMode:
+			var out *checker.PeerHealthOutput
+			out, err = d.checker.CheckPeerHealth(withTimeout, req.ma)
+			if out != nil {
+				out.CachedAt = cachedAt
+				d.metrics.observePeerHealthCheck(out)
+			}
+			data = out
+		default:
+			var out *checker.PeerCheckOutput
+			out, err = d.checker.CheckPeer(withTimeout, req.ma, req.cidKey, req.opts)
+			if out != nil {
+				out.CachedAt = cachedAt
+				d.metrics.observePeerCheck(out)
+			}
+			data = out
+		}
+		if err != nil {
+			d.metrics.observeCheckError(req.mode())
+			return cachedCheck{}, err
+		}
+
+		body, err := json.Marshal(data)
+		if err != nil {
+			return cachedCheck{}, err
+		}
+		res := cachedCheck{CachedAt: cachedAt, Body: append(body, '\n')}
+		d.checkCache.add(key, res)
+		return res, nil
+	})
+	if err != nil {
+		return cachedCheck{}, err
+	}
+
+	if d.checkCache != nil {
+		if req.opts.Fresh {
+			header.Add("Cache-Status", "ipfs-check; fwd=request; stored")
+		} else {
+			header.Add("Cache-Status", "ipfs-check; fwd=miss; stored")
+		}
+	}
+	return res, nil
+}
+
+// withRequestID returns the context of the request, logging with a new
+// request ID that is also returned in the response headers
+func withRequestID(w http.ResponseWriter, r *http.Request) context.Context {
+	id := newRequestID()
+	w.Header().Set(requestIDHeader, id)
+	ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+	return withLogger(ctx, logger(ctx).With("requestID", id))
+}
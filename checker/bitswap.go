@@ -0,0 +1,369 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	bsmsgpb "github.com/ipfs/boxo/bitswap/message/pb"
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const blockNotDeliveredError = "peer claimed to have the block but did not deliver it"
+
+// BitswapProtocols are the Bitswap protocol versions, in order of preference
+var BitswapProtocols = []protocol.ID{
+	bsnet.ProtocolBitswap,
+	bsnet.ProtocolBitswapOneOne,
+	bsnet.ProtocolBitswapOneZero,
+	bsnet.ProtocolBitswapNoVers,
+}
+
+// BitswapCheckOutput is the result of the Bitswap probe
+type BitswapCheckOutput struct {
+	Duration  time.Duration
+	Found     bool
+	Responded bool
+	Error     string
+	// Protocol is the Bitswap protocol version negotiated with the peer
+	Protocol string
+	// LegacyOnly is true when the peer doesn't speak Bitswap 1.2.0, and thus has no WANT-HAVE support
+	LegacyOnly bool
+	// SupportedProtocols maps each Bitswap protocol version to whether the peer speaks it, when probed
+	SupportedProtocols map[string]bool
+	// ConnectDuration is the time taken to connect to the peer and open a Bitswap stream
+	ConnectDuration time.Duration
+	// FirstResponseDuration is the time between sending the want and the first response for the CID (HAVE, DONT_HAVE or block)
+	FirstResponseDuration time.Duration
+	// BlockDuration is the time between sending the want and receiving the block, when the peer sent it
+	BlockDuration time.Duration
+	// BlockSize is the size in bytes of the block, when the peer sent it
+	BlockSize int
+	// BlockReceived is true when the peer delivered a block, as opposed to only claiming to have it
+	BlockReceived bool
+	// BlockValid is true when the delivered block's multihash matches the CID
+	BlockValid bool
+}
+
+// bitswapProbe sends a WANT-HAVE for the CID to the peer, or a WANT-BLOCK
+// with CheckOptions.GetBlock
+type bitswapProbe struct{}
+
+func (bitswapProbe) Name() string {
+	return TransportBitswap
+}
+
+// Applies unless identify reported the protocols of the peer without Bitswap.
+// When identify didn't complete, e.g. through a relay, opening a stream tells.
+func (bitswapProbe) Applies(t Target) bool {
+	protos, err := t.Host.Peerstore().GetProtocols(t.ID)
+	return err != nil || len(protos) == 0 || speaksBitswap(t.Host, t.ID)
+}
+
+func (bitswapProbe) Run(ctx context.Context, t Target, c cid.Cid, opts CheckOptions) ProbeResult {
+	proto, streamDuration, err := dialBitswap(ctx, t.Host, t.AddrInfo)
+	if err != nil {
+		return BitswapCheckOutput{Error: err.Error()}
+	}
+
+	// since we pass a libp2p host that's already connected to the peer the actual connection maddr we pass in doesn't matter
+	p2pAddr, _ := multiaddr.NewMultiaddr("/p2p/" + t.ID.String())
+	out := checkBitswapCID(ctx, t.Host, c, p2pAddr, opts.GetBlock)
+	out.Protocol = string(proto)
+	out.LegacyOnly = isLegacyBitswapProtocol(proto)
+	out.ConnectDuration = t.ConnectDuration + streamDuration
+	if opts.ProbeBitswapProtocols {
+		out.SupportedProtocols = probeBitswapProtocols(ctx, t.Host, t.ID)
+	}
+	return out
+}
+
+func (bitswapProbe) DecodeResult(data []byte) (ProbeResult, error) {
+	var out BitswapCheckOutput
+	err := json.Unmarshal(data, &out)
+	return out, err
+}
+
+// DataAvailable returns whether the peer has the block
+func (out BitswapCheckOutput) DataAvailable() bool {
+	return out.Found
+}
+
+// speaksBitswap returns whether the peer announced Bitswap support via
+// identify, or negotiated it on a stream
+func speaksBitswap(h host.Host, p peer.ID) bool {
+	protos, err := h.Peerstore().SupportsProtocols(p, BitswapProtocols...)
+	return err == nil && len(protos) > 0
+}
+
+// dialBitswap connects to the peer, if not connected yet, and opens a Bitswap
+// stream, returning the negotiated protocol and the time it took.
+func dialBitswap(ctx context.Context, h host.Host, ai peer.AddrInfo) (_ protocol.ID, _ time.Duration, err error) {
+	ctx, span := tracer.Start(ctx, "dialBitswap", trace.WithAttributes(attribute.Stringer("peer", ai.ID)))
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	connectCtx, connectSpan := tracer.Start(ctx, "Connect")
+	endSpan(connectSpan, h.Connect(connectCtx, ai))
+	// Call NewStream to force NAT hole punching. see https://github.com/libp2p/go-libp2p/issues/2714
+	streamCtx, streamSpan := tracer.Start(ctx, "NewStream")
+	s, err := h.NewStream(streamCtx, ai.ID, BitswapProtocols...)
+	endSpan(streamSpan, err)
+	if err != nil {
+		return "", 0, err
+	}
+	_ = s.Close()
+	span.SetAttributes(attribute.String("protocol", string(s.Protocol())))
+	return s.Protocol(), time.Since(start), nil
+}
+
+// checkBitswapCID sends a WANT-HAVE for the CID to the peer and waits for its
+// response. When getBlock is true, a WANT-BLOCK is sent instead, and the check
+// waits for the block to be delivered and verifies it against the CID.
+//
+// Adapted from vole's CheckBitswapCID, to record the timing of each phase.
+// When passing a host, it should be only connected to the passed multiaddr.
+func checkBitswapCID(ctx context.Context, host host.Host, c cid.Cid, ma multiaddr.Multiaddr, getBlock bool) (out BitswapCheckOutput) {
+	ctx, span := tracer.Start(ctx, "checkBitswapCID", trace.WithAttributes(
+		attribute.Stringer("cid", c),
+		attribute.Stringer("multiaddr", ma),
+		attribute.Bool("getBlock", getBlock),
+	))
+	log := Logger(ctx).With("cid", c, "multiaddr", ma)
+	log.Debug("Start of Bitswap check", "testHost", host.ID())
+	start := time.Now()
+	defer func() {
+		out.Duration = time.Since(start)
+		log.Debug("End of Bitswap check", "found", out.Found, "responded", out.Responded, "duration", out.Duration, "err", out.Error)
+		span.SetAttributes(
+			attribute.Bool("found", out.Found),
+			attribute.Bool("responded", out.Responded),
+		)
+		if out.Error != "" {
+			span.SetStatus(codes.Error, out.Error)
+		}
+		span.End()
+	}()
+
+	ai, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	if err := host.Connect(ctx, *ai); err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	// Create a new stream to ensure we wait for hole punching even if it takes longer than the built-in limit in the Bitswap implementation
+	s, err := host.NewStream(tctx, ai.ID, BitswapProtocols...)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	_ = s.Close()
+
+	bs := bsnet.NewFromIpfsHost(host, routinghelpers.Null{})
+	msg := bsmsg.New(false)
+	wantType := bsmsgpb.Message_Wantlist_Have
+	if getBlock {
+		wantType = bsmsgpb.Message_Wantlist_Block
+	}
+	msg.AddEntry(c, 0, wantType, true)
+
+	rcv := &bitswapReceiver{
+		target: ai.ID,
+		result: make(chan bitswapMsgOrErr),
+	}
+
+	bs.Start(rcv)
+	defer bs.Stop()
+
+	sent := time.Now()
+	if err := bs.SendMessage(ctx, ai.ID, msg); err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	// in case for some reason we're sent a bunch of messages (e.g. wants) from a peer without them responding to our query
+	sctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	for {
+		var res bitswapMsgOrErr
+		select {
+		case res = <-rcv.result:
+		case <-sctx.Done():
+			if out.Found && getBlock {
+				out.Error = blockNotDeliveredError
+			}
+			return out
+		}
+
+		if res.err != nil {
+			out.Responded = true
+			out.Error = res.err.Error()
+			return out
+		}
+
+		// Blocks are keyed by the CID computed from their data, so a block with
+		// unexpected data doesn't match the CID we asked for. It is only
+		// reported as corrupt when no other block of the message matches.
+		match, mismatch := findBlock(res.msg.Blocks(), c)
+		if match == nil && getBlock {
+			match = mismatch
+		}
+		if match != nil {
+			elapsed := time.Since(sent)
+			if !out.Responded {
+				out.FirstResponseDuration = elapsed
+			}
+			out.Found = true
+			out.Responded = true
+			out.BlockReceived = true
+			out.BlockDuration = elapsed
+			out.BlockSize = len(match.RawData())
+			out.BlockValid = blockMatchesCID(match.RawData(), c)
+			if !out.BlockValid {
+				out.Error = fmt.Sprintf("peer delivered a block whose multihash doesn't match %s", c)
+			}
+			return out
+		}
+
+		for _, have := range res.msg.Haves() {
+			if have.Equals(c) && !out.Found {
+				out.Found = true
+				out.Responded = true
+				out.FirstResponseDuration = time.Since(sent)
+				if !getBlock {
+					return out
+				}
+			}
+		}
+
+		for _, dontHave := range res.msg.DontHaves() {
+			if dontHave.Equals(c) {
+				if !out.Responded {
+					out.FirstResponseDuration = time.Since(sent)
+				}
+				out.Responded = true
+				if out.Found {
+					out.Error = blockNotDeliveredError
+				}
+				return out
+			}
+		}
+	}
+}
+
+// findBlock returns the block of blks whose data matches the CID, or else
+// the first block that doesn't match
+func findBlock(blks []blocks.Block, c cid.Cid) (match, mismatch blocks.Block) {
+	for _, b := range blks {
+		if blockMatchesCID(b.RawData(), c) {
+			return b, nil
+		}
+		if mismatch == nil {
+			mismatch = b
+		}
+	}
+	return nil, mismatch
+}
+
+// blockMatchesCID returns whether the multihash of the data matches the CID's
+func blockMatchesCID(data []byte, c cid.Cid) bool {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(sum.Hash(), c.Hash())
+}
+
+// isLegacyBitswapProtocol returns whether the negotiated Bitswap protocol
+// predates 1.2.0, which introduced WANT-HAVE and DONT_HAVE.
+func isLegacyBitswapProtocol(proto protocol.ID) bool {
+	return proto != "" && proto != bsnet.ProtocolBitswap
+}
+
+// probeBitswapProtocols opens a stream for each Bitswap protocol version
+// separately, and returns whether the peer supports it.
+func probeBitswapProtocols(ctx context.Context, h host.Host, p peer.ID) map[string]bool {
+	out := make(map[string]bool, len(BitswapProtocols))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, proto := range BitswapProtocols {
+		wg.Add(1)
+		go func(proto protocol.ID) {
+			defer wg.Done()
+
+			streamCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+			defer cancel()
+			s, err := h.NewStream(streamCtx, p, proto)
+			if err == nil {
+				_ = s.Close()
+			}
+
+			mu.Lock()
+			out[string(proto)] = err == nil
+			mu.Unlock()
+		}(proto)
+	}
+	wg.Wait()
+	return out
+}
+
+type bitswapReceiver struct {
+	target peer.ID
+	result chan bitswapMsgOrErr
+}
+
+type bitswapMsgOrErr struct {
+	msg bsmsg.BitSwapMessage
+	err error
+}
+
+func (r *bitswapReceiver) ReceiveMessage(ctx context.Context, sender peer.ID, incoming bsmsg.BitSwapMessage) {
+	if r.target != sender {
+		select {
+		case <-ctx.Done():
+		case r.result <- bitswapMsgOrErr{err: fmt.Errorf("expected peerID %v, got %v", r.target, sender)}:
+		}
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case r.result <- bitswapMsgOrErr{msg: incoming}:
+	}
+}
+
+func (r *bitswapReceiver) ReceiveError(err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case r.result <- bitswapMsgOrErr{err: err}:
+	}
+}
+
+func (r *bitswapReceiver) PeerConnected(id peer.ID) {}
+
+func (r *bitswapReceiver) PeerDisconnected(id peer.ID) {}
+
+var _ bsnet.Receiver = (*bitswapReceiver)(nil)
@@ -0,0 +1,29 @@
+package checker
+
+import (
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBlock(t *testing.T) {
+	wanted := blocks.NewBlock([]byte("wanted"))
+	other := blocks.NewBlock([]byte("other"))
+	// a corrupt block is keyed by the CID of its data
+	corrupt := blocks.NewBlock([]byte("corrupt"))
+
+	match, mismatch := findBlock([]blocks.Block{other, wanted}, wanted.Cid())
+	require.Equal(t, wanted, match)
+	require.Nil(t, mismatch)
+
+	match, mismatch = findBlock([]blocks.Block{corrupt, other}, wanted.Cid())
+	require.Nil(t, match)
+	require.Equal(t, corrupt, mismatch)
+
+	// the data matters, not the codec of the CID
+	v1 := cid.NewCidV1(cid.Raw, wanted.Cid().Hash())
+	match, _ = findBlock([]blocks.Block{wanted}, v1)
+	require.Equal(t, wanted, match)
+}
@@ -0,0 +1,573 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/contentrouter"
+	"github.com/ipfs/go-cid"
+	dhtpb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CheckOptions are the options of a check
+type CheckOptions struct {
+	// IPNIURL is the indexer to query, DefaultIndexerURL when empty
+	IPNIURL string
+	// ProbeBitswapProtocols probes each Bitswap protocol version separately
+	ProbeBitswapProtocols bool
+	// GetBlock fetches the block with a WANT-BLOCK and verifies it, rather than only sending a WANT-HAVE
+	GetBlock bool
+	// Probes are the names of the probes run against each peer, see
+	// ProbeNames. By default, the first registered probe that applies runs.
+	Probes []string
+	// Fresh looks up the providers again, rather than using the ones cached
+	// with WithProvidersCacheTTL
+	Fresh bool
+}
+
+func (opts CheckOptions) ipniURL() string {
+	if opts.IPNIURL == "" {
+		return DefaultIndexerURL
+	}
+	return opts.IPNIURL
+}
+
+// ProviderOutput is the result of the check of a provider found by CheckCID
+type ProviderOutput struct {
+	ID                         string
+	ConnectionError            string
+	Addrs                      []string
+	ConnectionMaddrs           []string
+	DataAvailableOverBitswap   BitswapCheckOutput
+	DataAvailableOverGraphsync *GraphsyncCheckOutput
+	Source                     string
+	// Transport is the first probe that ran, used to check the data availability: bitswap or graphsync
+	Transport string
+	// Probes are the results of the probes that ran against the provider
+	Probes ProbeResults
+	// Protocols are the transport protocols advertised in the provider record, when found in IPNI
+	Protocols []string
+	// Metadata maps each advertised protocol to its metadata decoded from the indexer response
+	Metadata map[string]IPNIMetadata
+	// ProtocolMismatches lists the differences between the advertised protocols and the ones the provider speaks
+	ProtocolMismatches []string
+	// CachedAt is when the check ran, set by the ipfs-check server as results may be served from its cache
+	CachedAt time.Time
+}
+
+// CheckCID finds providers of a given CID, using the DHT and IPNI
+// concurrently. The connectivity of each provider found is checked, and the
+// probes are run against it: by default Bitswap, or Graphsync for providers
+// that only speak Graphsync, like Filecoin storage providers.
+func (chk *Checker) CheckCID(ctx context.Context, cidKey cid.Cid, opts CheckOptions) ([]ProviderOutput, error) {
+	ctx, span := tracer.Start(ctx, "runCidCheck", trace.WithAttributes(attribute.Stringer("cid", cidKey)))
+	defer span.End()
+
+	selected, err := selectProbes(opts.Probes)
+	if err != nil {
+		return nil, err
+	}
+
+	crClient, err := client.New(opts.ipniURL(),
+		client.WithHTTPClient(chk.ipniHTTPClient),
+		client.WithStreamResultsRequired(),               // // https://specs.ipfs.tech/routing/http-routing-v1/#streaming
+		client.WithProtocolFilter(DefaultProtocolFilter), // IPIP-484
+		client.WithDisabledLocalFiltering(false),         // force local filtering in case remote server does not support IPIP-484
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create content router client: %w", err)
+	}
+
+	queryCtx, cancelQuery := context.WithCancel(ctx)
+	defer cancelQuery()
+
+	// half of the max providers count per source
+	providersPerSource := maxProvidersCount >> 1
+	if maxProvidersCount == 1 {
+		// Ensure at least one provider from each source when maxProvidersCount is 1
+		providersPerSource = 1
+	}
+
+	cacheKey := providersCacheKey(cidKey, opts.ipniURL())
+	var found foundProviders
+	var cached bool
+	if !opts.Fresh {
+		found, cached = chk.providers.get(cacheKey)
+	}
+
+	var dhtProvsCh <-chan peer.AddrInfo
+	var ipniProvsCh <-chan ipniProvider
+	if cached {
+		Logger(ctx).Debug("Using the cached providers", "cid", cidKey, "dht", len(found.dht), "ipni", len(found.ipni))
+		dhtProvsCh, ipniProvsCh = found.channels()
+	} else {
+		// Find providers with DHT and IPNI concurrently (each half of the max providers count)
+		dhtProvsCh = chk.dht.FindProvidersAsync(queryCtx, cidKey, providersPerSource)
+		ipniProvsCh = findProvidersInIPNI(queryCtx, crClient, cidKey, providersPerSource)
+	}
+
+	out := make([]ProviderOutput, 0, maxProvidersCount)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var providersCount int
+	var done bool
+
+	for !done {
+		var provider peer.AddrInfo
+		var open bool
+		var source string
+		var advertised *ipniProvider
+
+		select {
+		case provider, open = <-dhtProvsCh:
+			if !open {
+				dhtProvsCh = nil
+				if ipniProvsCh == nil {
+					done = true
+				}
+				continue
+			}
+			source = SourceDHT
+			if !cached {
+				found.dht = append(found.dht, provider)
+			}
+		case ipniProv, ok := <-ipniProvsCh:
+			if !ok {
+				ipniProvsCh = nil
+				if dhtProvsCh == nil {
+					done = true
+				}
+				continue
+			}
+			provider = ipniProv.AddrInfo
+			source = SourceIPNI
+			advertised = &ipniProv
+			if !cached {
+				found.ipni = append(found.ipni, ipniProv)
+			}
+		}
+		providersCount++
+		if providersCount == maxProvidersCount {
+			done = true
+		}
+
+		wg.Add(1)
+		go func(provider peer.AddrInfo, src string, advertised *ipniProvider) {
+			defer wg.Done()
+			ctx, span := tracer.Start(ctx, "checkProvider", trace.WithAttributes(
+				attribute.Stringer("peer", provider.ID),
+				attribute.String("source", src),
+			))
+			defer span.End()
+
+			outputAddrs := []string{}
+			if len(provider.Addrs) > 0 {
+				for _, addr := range provider.Addrs {
+					if manet.IsPublicAddr(addr) { // only return public addrs
+						outputAddrs = append(outputAddrs, addr.String())
+					}
+				}
+			} else {
+				// If no maddrs were returned from the FindProvider rpc call, try to get them from the DHT
+				findCtx, findSpan := tracer.Start(ctx, "FindPeer")
+				peerAddrs, err := chk.dht.FindPeer(findCtx, provider.ID)
+				endSpan(findSpan, err)
+				if err == nil {
+					for _, addr := range peerAddrs.Addrs {
+						if manet.IsPublicAddr(addr) { // only return public addrs
+							// Add to both output and to provider addrs for the check
+							outputAddrs = append(outputAddrs, addr.String())
+							provider.Addrs = append(provider.Addrs, addr)
+						}
+					}
+				}
+			}
+
+			provOutput := ProviderOutput{
+				ID:                       provider.ID.String(),
+				Addrs:                    outputAddrs,
+				DataAvailableOverBitswap: BitswapCheckOutput{},
+				Source:                   src,
+			}
+			if advertised != nil {
+				provOutput.Protocols = advertised.Protocols
+				provOutput.Metadata = advertised.Metadata
+			}
+
+			testHost, err := chk.newTestHost()
+			if err != nil {
+				Logger(ctx).Error("Error creating test host", "err", err)
+				return
+			}
+			defer testHost.Close()
+
+			// Test Is the target connectable
+			dialCtx, dialCancel := context.WithTimeout(ctx, time.Second*15)
+			defer dialCancel()
+
+			target, connErr := connect(dialCtx, testHost, provider)
+			if connErr == nil {
+				provOutput.Probes = chk.runProbes(ctx, selected, target, cidKey, opts)
+				if len(provOutput.Probes) == 0 {
+					connErr = errNoProbeApplies(selected)
+				}
+			}
+
+			if connErr != nil {
+				provOutput.ConnectionError = connErr.Error()
+			} else {
+				provOutput.Transport, provOutput.DataAvailableOverBitswap, provOutput.DataAvailableOverGraphsync = transportResults(selected, provOutput.Probes)
+
+				for _, c := range testHost.Network().ConnsToPeer(provider.ID) {
+					provOutput.ConnectionMaddrs = append(provOutput.ConnectionMaddrs, c.RemoteMultiaddr().String())
+				}
+			}
+
+			if advertised != nil && provOutput.ConnectionError == "" {
+				provOutput.ProtocolMismatches = protocolMismatches(advertised.Protocols, speaksBitswap(testHost, provider.ID), speaksGraphsync(testHost, provider.ID))
+			}
+			Logger(ctx).Debug("Checked provider", "peer", provider.ID, "source", src, "transport", provOutput.Transport, "connectionError", provOutput.ConnectionError)
+
+			mu.Lock()
+			out = append(out, provOutput)
+			mu.Unlock()
+		}(provider, source, advertised)
+	}
+	cancelQuery()
+	// Lookups interrupted by the end of the check may have missed providers
+	if !cached && ctx.Err() == nil {
+		chk.providers.add(cacheKey, found)
+	}
+
+	// Wait for all goroutines to finish
+	wg.Wait()
+
+	Logger(ctx).Info("CID check done", "cid", cidKey, "providers", len(out))
+	return out, nil
+}
+
+// transportResults returns the first of the selected probes that ran, and
+// the results of the Bitswap and Graphsync probes among the results
+func transportResults(selected []Probe, results ProbeResults) (transport string, bitswap BitswapCheckOutput, graphsync *GraphsyncCheckOutput) {
+	for _, p := range selected {
+		res, ok := results[p.Name()]
+		if !ok {
+			continue
+		}
+		if transport == "" {
+			transport = p.Name()
+		}
+		switch res := res.(type) {
+		case BitswapCheckOutput:
+			bitswap = res
+		case GraphsyncCheckOutput:
+			graphsync = &res
+		}
+	}
+	return transport, bitswap, graphsync
+}
+
+// PeerCheckOutput is the result of CheckPeer
+type PeerCheckOutput struct {
+	ConnectionError                string
+	PeerFoundInDHT                 map[string]int
+	ProviderRecordFromPeerInDHT    bool
+	ProviderRecordFromPeerInIPNI   bool
+	ProviderStatusInIPNI           IPNIProviderCheckOutput
+	ProviderRecordReplicationInDHT ProviderRecordReplication
+	ProviderRecordFreshnessInDHT   ProviderRecordFreshness
+	ConnectionMaddrs               []string
+	DataAvailableOverBitswap       BitswapCheckOutput
+	DataAvailableOverGraphsync     *GraphsyncCheckOutput
+	// Transport is the first probe that ran, used to check the data availability: bitswap or graphsync
+	Transport string
+	// Probes are the results of the probes that ran against the peer
+	Probes ProbeResults
+	// CachedAt is when the check ran, set by the ipfs-check server as results may be served from its cache
+	CachedAt time.Time
+}
+
+// CheckPeer checks the connectivity of a given peer, either with just
+// /p2p/<peer ID> or a specific multiaddr, and runs the probes for the CID
+// against it, Bitswap by default. The provider records of the peer for the
+// CID are looked up in the DHT and IPNI.
+func (chk *Checker) CheckPeer(ctx context.Context, ma multiaddr.Multiaddr, c cid.Cid, opts CheckOptions) (*PeerCheckOutput, error) {
+	ai, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := tracer.Start(ctx, "runPeerCheck", trace.WithAttributes(
+		attribute.Stringer("cid", c),
+		attribute.Stringer("peer", ai.ID),
+	))
+	defer span.End()
+
+	addrMap, peerAddrDHTErr := peerAddrsInDHT(ctx, chk.dht, chk.dhtMessenger, ai.ID)
+
+	var inDHT, inIPNI bool
+	var replication ProviderRecordReplication
+	var ipniStatus IPNIProviderCheckOutput
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		inDHT = providerRecordFromPeerInDHT(ctx, chk.dht, c, ai.ID)
+		wg.Done()
+	}()
+	go func() {
+		replication = providerRecordReplicationInDHT(ctx, chk.dht, chk.dhtMessenger, c, ai.ID)
+		wg.Done()
+	}()
+	go func() {
+		inIPNI = chk.providerRecordFromPeerInIPNI(ctx, opts.ipniURL(), c, ai.ID)
+		wg.Done()
+	}()
+	go func(ai peer.AddrInfo) {
+		ipniStatus = ipniProviderCheck(ctx, chk.h, chk.ipniTransport, opts.ipniURL(), ai)
+		wg.Done()
+	}(*ai)
+	wg.Wait()
+
+	observations := chk.providerRecordObservations.add(c, ai.ID, replication, time.Now())
+
+	out := &PeerCheckOutput{
+		ProviderRecordFromPeerInDHT:    inDHT,
+		ProviderRecordFromPeerInIPNI:   inIPNI,
+		ProviderStatusInIPNI:           ipniStatus,
+		ProviderRecordReplicationInDHT: replication,
+		ProviderRecordFreshnessInDHT:   providerRecordFreshness(observations),
+		PeerFoundInDHT:                 addrMap,
+	}
+
+	selected, err := selectProbes(opts.Probes)
+	if err != nil {
+		return nil, err
+	}
+
+	// If peerID given,but no addresses check the DHT
+	if len(ai.Addrs) == 0 {
+		if peerAddrDHTErr != nil {
+			// PeerID is not resolvable via the DHT
+			Logger(ctx).Info("Peer check done, failed to connect", "peer", ai.ID, "err", peerAddrDHTErr)
+			out.ConnectionError = peerAddrDHTErr.Error()
+			return out, nil
+		}
+		for a := range addrMap {
+			ma, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				Logger(ctx).Warn("Error parsing multiaddr", "multiaddr", a, "err", err)
+				continue
+			}
+			ai.Addrs = append(ai.Addrs, ma)
+		}
+	}
+
+	testHost, err := chk.newTestHost()
+	if err != nil {
+		return nil, fmt.Errorf("server error: %w", err)
+	}
+	defer testHost.Close()
+
+	// Test Is the target connectable
+	dialCtx, dialCancel := context.WithTimeout(ctx, time.Second*120)
+	target, connErr := connect(dialCtx, testHost, *ai)
+	dialCancel()
+	if connErr == nil {
+		// If so is the data available over the protocols of the probes?
+		out.Probes = chk.runProbes(ctx, selected, target, c, opts)
+		if len(out.Probes) == 0 {
+			connErr = errNoProbeApplies(selected)
+		}
+	}
+	if connErr != nil {
+		Logger(ctx).Info("Peer check done, failed to connect", "peer", ai.ID, "err", connErr)
+		out.ConnectionError = connErr.Error()
+		return out, nil
+	}
+	out.Transport, out.DataAvailableOverBitswap, out.DataAvailableOverGraphsync = transportResults(selected, out.Probes)
+
+	// Get all connection maddrs to the peer (in case we hole punched, there will usually be two: limited relay and direct)
+	for _, c := range testHost.Network().ConnsToPeer(ai.ID) {
+		out.ConnectionMaddrs = append(out.ConnectionMaddrs, c.RemoteMultiaddr().String())
+	}
+
+	Logger(ctx).Info("Peer check done", "peer", ai.ID, "cid", c, "transport", out.Transport, "found", out.Probes.DataAvailable())
+	return out, nil
+}
+
+func peerAddrsInDHT(ctx context.Context, d Kademlia, messenger *dhtpb.ProtocolMessenger, p peer.ID) (_ map[string]int, err error) {
+	ctx, span := tracer.Start(ctx, "peerAddrsInDHT", trace.WithAttributes(attribute.Stringer("peer", p)))
+	defer func() { endSpan(span, err) }()
+
+	closestPeers, err := d.GetClosestPeers(ctx, string(p))
+	if err != nil {
+		return nil, err
+	}
+
+	resCh := make(chan *peer.AddrInfo, len(closestPeers))
+
+	numSuccessfulResponses := execOnMany(ctx, 0.3, time.Second*3, func(ctx context.Context, peerToQuery peer.ID) error {
+		endResults, err := messenger.GetClosestPeers(ctx, peerToQuery, p)
+		if err == nil {
+			for _, r := range endResults {
+				if r.ID == p {
+					resCh <- r
+					return nil
+				}
+			}
+			resCh <- nil
+		}
+		return err
+	}, closestPeers, false)
+	close(resCh)
+
+	if numSuccessfulResponses == 0 {
+		return nil, fmt.Errorf("host had trouble querying the DHT")
+	}
+
+	addrMap := make(map[string]int)
+	for r := range resCh {
+		if r == nil {
+			continue
+		}
+		for _, addr := range r.Addrs {
+			addrMap[addr.String()]++
+		}
+	}
+
+	return addrMap, nil
+}
+
+func providerRecordFromPeerInDHT(ctx context.Context, d Kademlia, c cid.Cid, p peer.ID) bool {
+	queryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	provsCh := d.FindProvidersAsync(queryCtx, c, 0)
+	for {
+		select {
+		case prov, ok := <-provsCh:
+			if !ok {
+				return false
+			}
+			if prov.ID == p {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (chk *Checker) providerRecordFromPeerInIPNI(ctx context.Context, ipniURL string, c cid.Cid, p peer.ID) bool {
+	ctx, span := tracer.Start(ctx, "providerRecordFromPeerInIPNI", trace.WithAttributes(attribute.String("indexer", ipniURL)))
+	defer span.End()
+
+	crClient, err := client.New(ipniURL, client.WithStreamResultsRequired(), client.WithHTTPClient(chk.ipniHTTPClient))
+	if err != nil {
+		Logger(ctx).Error("Failed to create content router client", "err", err)
+		return false
+	}
+	routerClient := contentrouter.NewContentRoutingClient(crClient)
+
+	queryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	provsCh := routerClient.FindProvidersAsync(queryCtx, c, 0)
+	for {
+		select {
+		case prov, ok := <-provsCh:
+			if !ok {
+				return false
+			}
+			if prov.ID == p {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Taken from the FullRT DHT client implementation
+//
+// execOnMany executes the given function on each of the peers, although it may only wait for a certain chunk of peers
+// to respond before considering the results "good enough" and returning.
+//
+// If sloppyExit is true then this function will return without waiting for all of its internal goroutines to close.
+// If sloppyExit is true then the passed in function MUST be able to safely complete an arbitrary amount of time after
+// execOnMany has returned (e.g. do not write to resources that might get closed or set to nil and therefore result in
+// a panic instead of just returning an error).
+func execOnMany(ctx context.Context, waitFrac float64, timeoutPerOp time.Duration, fn func(context.Context, peer.ID) error, peers []peer.ID, sloppyExit bool) (numSuccess int) {
+	ctx, span := tracer.Start(ctx, "execOnMany", trace.WithAttributes(attribute.Int("peers", len(peers))))
+	defer func() {
+		span.SetAttributes(attribute.Int("successes", numSuccess))
+		span.End()
+	}()
+
+	if len(peers) == 0 {
+		return 0
+	}
+
+	// having a buffer that can take all of the elements is basically a hack to allow for sloppy exits that clean up
+	// the goroutines after the function is done rather than before
+	errCh := make(chan error, len(peers))
+	numSuccessfulToWaitFor := int(float64(len(peers)) * waitFrac)
+
+	putctx, cancel := context.WithTimeout(ctx, timeoutPerOp)
+	defer cancel()
+
+	for _, p := range peers {
+		go func(p peer.ID) {
+			errCh <- fn(putctx, p)
+		}(p)
+	}
+
+	var numDone, successSinceLastTick int
+	var ticker *time.Ticker
+	var tickChan <-chan time.Time
+
+	for numDone < len(peers) {
+		select {
+		case err := <-errCh:
+			numDone++
+			if err == nil {
+				numSuccess++
+				if numSuccess >= numSuccessfulToWaitFor && ticker == nil {
+					// Once there are enough successes, wait a little longer
+					ticker = time.NewTicker(time.Millisecond * 500)
+					defer ticker.Stop()
+					tickChan = ticker.C
+					successSinceLastTick = numSuccess
+				}
+				// This is equivalent to numSuccess * 2 + numFailures >= len(peers) and is a heuristic that seems to be
+				// performing reasonably.
+				// TODO: Make this metric more configurable
+				// TODO: Have better heuristics in this function whether determined from observing static network
+				// properties or dynamically calculating them
+				if numSuccess+numDone >= len(peers) {
+					cancel()
+					if sloppyExit {
+						return numSuccess
+					}
+				}
+			}
+		case <-tickChan:
+			if numSuccess > successSinceLastTick {
+				// If there were additional successes, then wait another tick
+				successSinceLastTick = numSuccess
+			} else {
+				cancel()
+				if sloppyExit {
+					return numSuccess
+				}
+			}
+		}
+	}
+	return numSuccess
+}
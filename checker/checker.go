@@ -0,0 +1,234 @@
+// Package checker checks the retrievability of data from IPFS peers: it
+// finds the providers of a CID in the Amino DHT and IPNI, connects to them and
+// checks whether they serve the data over Bitswap or Graphsync. It is the
+// engine of the ipfs-check HTTP server, which can be embedded in other
+// programs.
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/libp2p/go-libp2p"
+	dhtpb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	mplex "github.com/libp2p/go-libp2p-mplex"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kademlia is the DHT client used to find providers and peers, e.g. the
+// go-libp2p-kad-dht client or the accelerated fullrt client
+type Kademlia interface {
+	routing.Routing
+	GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error)
+	Close() error
+}
+
+const (
+	// number of providers at which to stop looking for providers in the DHT
+	// When doing a check only with a CID
+	maxProvidersCount = 10
+
+	// Sources of the providers found by CheckCID
+	SourceIPNI = "IPNI"
+	SourceDHT  = "Amino DHT"
+
+	// DefaultIndexerURL is the IPNI indexer queried when CheckOptions.IPNIURL is empty
+	DefaultIndexerURL = "https://cid.contact"
+	// DefaultDHTProtocol is the protocol of the Amino DHT
+	DefaultDHTProtocol = "/ipfs/kad/1.0.0"
+	// DefaultUserAgent is the user agent of the test hosts and the requests to indexers
+	DefaultUserAgent = "ipfs-check"
+)
+
+// DefaultProtocolFilter is the IPIP-484 filter of the provider records, the
+// transports the checks support
+// TODO: make this configurable, and add support and trustless retrieval probe for transport-ipfs-gateway-http
+var DefaultProtocolFilter = []string{ProtocolBitswap, ProtocolGraphsync, "unknown"}
+
+// Checker runs checks from a libp2p host and a DHT client. Each check
+// connects to the peers from a separate test host, so that checks are
+// isolated from each other.
+type Checker struct {
+	h              host.Host
+	dht            Kademlia
+	dhtProtocol    protocol.ID
+	dhtMessenger   *dhtpb.ProtocolMessenger
+	createTestHost func() (host.Host, error)
+	testHosts      testHostPool
+	metrics        *checkerMetrics
+	userAgent      string
+	// ipniTransport traces the requests to the indexers and records their latency
+	ipniTransport http.RoundTripper
+	// ipniHTTPClient is the HTTP client of the delegated routing clients of the indexers
+	ipniHTTPClient *http.Client
+	// providers caches the providers found by CheckCID, nil when disabled
+	providers *providersCache
+
+	providerRecordObservations providerRecordObservations
+}
+
+// Option configures a Checker
+type Option func(*Checker) error
+
+// WithHost sets the host used to query the DHT and the indexers. It is required.
+func WithHost(h host.Host) Option {
+	return func(chk *Checker) error {
+		chk.h = h
+		return nil
+	}
+}
+
+// WithDHT sets the DHT client, whose host must be the one of WithHost. It is required.
+func WithDHT(dht Kademlia) Option {
+	return func(chk *Checker) error {
+		chk.dht = dht
+		return nil
+	}
+}
+
+// WithDHTProtocol sets the protocol of the DHT requests sent directly to
+// peers, DefaultDHTProtocol by default
+func WithDHTProtocol(proto protocol.ID) Option {
+	return func(chk *Checker) error {
+		chk.dhtProtocol = proto
+		return nil
+	}
+}
+
+// WithTestHostFactory sets the function creating the hosts the checks connect
+// to peers from. By default, hosts only dialing public addresses are created,
+// with hole punching enabled.
+func WithTestHostFactory(create func() (host.Host, error)) Option {
+	return func(chk *Checker) error {
+		chk.createTestHost = create
+		return nil
+	}
+}
+
+// WithTestHostPoolSize sets the number of test hosts created in advance and
+// recycled between checks. Test hosts aren't reused when 0, the default.
+func WithTestHostPoolSize(size int) Option {
+	return func(chk *Checker) error {
+		if size < 0 {
+			return fmt.Errorf("invalid test host pool size %d", size)
+		}
+		chk.testHosts.size = size
+		return nil
+	}
+}
+
+// WithPrometheusRegisterer records the metrics of the checks with reg. No
+// metrics are recorded by default.
+func WithPrometheusRegisterer(reg prometheus.Registerer) Option {
+	return func(chk *Checker) error {
+		chk.metrics = newCheckerMetrics(reg)
+		return nil
+	}
+}
+
+// WithProvidersCacheTTL caches the providers found by CheckCID in the DHT and
+// IPNI for ttl, so that checks of the same CID don't look them up again,
+// unless CheckOptions.Fresh is set. Providers aren't cached by default.
+func WithProvidersCacheTTL(ttl time.Duration) Option {
+	return func(chk *Checker) error {
+		if ttl < 0 {
+			return fmt.Errorf("invalid providers cache TTL %s", ttl)
+		}
+		if ttl > 0 {
+			chk.providers = newProvidersCache(ttl)
+		}
+		return nil
+	}
+}
+
+// WithUserAgent sets the user agent of the test hosts and the requests to
+// indexers, DefaultUserAgent by default
+func WithUserAgent(ua string) Option {
+	return func(chk *Checker) error {
+		chk.userAgent = ua
+		return nil
+	}
+}
+
+// New returns a Checker, which must be closed once done. The host and the
+// DHT client aren't closed by the checker.
+func New(opts ...Option) (*Checker, error) {
+	chk := &Checker{
+		dhtProtocol: DefaultDHTProtocol,
+		userAgent:   DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		if err := opt(chk); err != nil {
+			return nil, err
+		}
+	}
+	if chk.h == nil {
+		return nil, errors.New("checker: a host is required")
+	}
+	if chk.dht == nil {
+		return nil, errors.New("checker: a DHT client is required")
+	}
+
+	pm, err := dhtProtocolMessenger(chk.dhtProtocol, chk.h)
+	if err != nil {
+		return nil, err
+	}
+	chk.dhtMessenger = pm
+
+	if chk.createTestHost == nil {
+		chk.createTestHost = func() (host.Host, error) {
+			// TODO: when behind NAT, this will fail to determine its own public addresses which will block it from running dctur and hole punching
+			// See https://github.com/libp2p/go-libp2p/issues/2941
+			return libp2p.New(
+				libp2p.ConnectionGater(&PrivateAddrFilterConnectionGater{}),
+				libp2p.DefaultMuxers,
+				libp2p.Muxer("/mplex/6.7.0", mplex.DefaultTransport),
+				libp2p.EnableHolePunching(),
+				libp2p.UserAgent(chk.userAgent),
+			)
+		}
+	}
+	chk.ipniTransport = newIPNITransport(chk.metrics)
+	chk.ipniHTTPClient = &http.Client{
+		Transport: &client.ResponseBodyLimitedTransport{
+			RoundTripper: chk.ipniTransport,
+			LimitBytes:   1 << 20,
+			UserAgent:    chk.userAgent,
+		},
+	}
+
+	chk.testHosts.metrics = chk.metrics
+	go chk.testHosts.fill(chk.createTestHost)
+
+	return chk, nil
+}
+
+// Close closes the test hosts still in use
+func (chk *Checker) Close() error {
+	chk.testHosts.closeAll()
+	return nil
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose checks log with l
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Logger returns the logger of the context, or the default logger
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
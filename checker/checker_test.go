@@ -0,0 +1,33 @@
+package checker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRequiresHostAndDHT(t *testing.T) {
+	h, err := newLocalTestHost()
+	require.NoError(t, err)
+	defer h.Close()
+	d, err := dht.New(context.Background(), h, dht.Mode(dht.ModeClient))
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = New(WithDHT(d))
+	require.Error(t, err)
+	_, err = New(WithHost(h))
+	require.Error(t, err)
+	_, err = New(WithHost(h), WithDHT(d), WithTestHostPoolSize(-1))
+	require.Error(t, err)
+
+	chk, err := New(WithHost(h), WithDHT(d), WithTestHostFactory(func() (host.Host, error) {
+		return libp2p.New(libp2p.NoListenAddrs)
+	}))
+	require.NoError(t, err)
+	require.NoError(t, chk.Close())
+}
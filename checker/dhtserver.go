@@ -0,0 +1,208 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	dhtpb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// slowDHTResponse is the latency above which a DHT server is considered slow.
+// The DHT client gives up on a peer after 10s, and favors faster peers.
+const slowDHTResponse = time.Second * 2
+
+// DHTServerCheckOutput describes whether a peer is a useful DHT server
+type DHTServerCheckOutput struct {
+	// IsServer is true when the peer answered at least one DHT request, i.e. it runs in DHT server mode
+	IsServer bool
+	// Useful is true when the peer answered all DHT requests with closer peers, and is known by at least
+	// one of its neighbors. It is false when none of the neighbors responded, as that couldn't be verified.
+	Useful       bool
+	FindNode     DHTQueryOutput
+	GetProviders DHTQueryOutput
+	GetValue     DHTQueryOutput
+	// Neighbors is the number of peers closest to the peer's key that responded to a FIND_NODE request for it
+	Neighbors int
+	// NeighborsWithPeer is the number of neighbors that returned the peer, i.e. that have it in their routing table
+	NeighborsWithPeer int
+	Warnings          []string
+}
+
+// DHTQueryOutput is the result of a single DHT request
+type DHTQueryOutput struct {
+	Duration time.Duration
+	// CloserPeers is the number of closer peers returned by the peer
+	CloserPeers int
+	// CloserPeersWithAddrs is the number of closer peers returned with addresses
+	CloserPeersWithAddrs int
+	Error                string
+}
+
+// dhtServerCheck sends FIND_NODE, GET_PROVIDERS and GET_VALUE requests to the
+// peer from the test host connected to it, and checks whether the closest
+// peers to its key have it in their routing table.
+func dhtServerCheck(ctx context.Context, chk *Checker, testHost host.Host, ai peer.AddrInfo) DHTServerCheckOutput {
+	out := DHTServerCheckOutput{Warnings: []string{}}
+
+	// The addresses of the peer are given by the user, so they are only
+	// dialed from the test host, never from the checker's host
+	messenger, err := dhtProtocolMessenger(chk.dhtProtocol, testHost)
+	if err != nil {
+		out.Warnings = append(out.Warnings, err.Error())
+		return out
+	}
+
+	// Look up our own key, for which the peer is unlikely to hold any record
+	self := testHost.ID()
+	mh, err := multihash.Sum([]byte(self), multihash.SHA2_256, -1)
+	if err != nil {
+		out.Warnings = append(out.Warnings, err.Error())
+		return out
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		out.FindNode = dhtQuery(ctx, func(ctx context.Context) ([]*peer.AddrInfo, error) {
+			return messenger.GetClosestPeers(ctx, ai.ID, self)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		out.GetProviders = dhtQuery(ctx, func(ctx context.Context) ([]*peer.AddrInfo, error) {
+			_, closer, err := messenger.GetProviders(ctx, ai.ID, mh)
+			return closer, err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		out.GetValue = dhtQuery(ctx, func(ctx context.Context) ([]*peer.AddrInfo, error) {
+			_, closer, err := messenger.GetValue(ctx, ai.ID, string(ipns.NameFromPeer(self).RoutingKey()))
+			return closer, err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		// The neighbors are found in the DHT, so they are asked from the checker's host
+		out.Neighbors, out.NeighborsWithPeer = peerInNeighborsRoutingTables(ctx, chk.dht, chk.dhtMessenger, ai.ID)
+	}()
+	wg.Wait()
+
+	out.evaluate()
+	return out
+}
+
+// evaluate sets whether the peer is a DHT server, and a useful one, from the
+// results of the requests
+func (out *DHTServerCheckOutput) evaluate() {
+	queries := map[string]DHTQueryOutput{
+		"FIND_NODE":     out.FindNode,
+		"GET_PROVIDERS": out.GetProviders,
+		"GET_VALUE":     out.GetValue,
+	}
+	answeredAll := true
+	for _, name := range []string{"FIND_NODE", "GET_PROVIDERS", "GET_VALUE"} {
+		q := queries[name]
+		switch {
+		case q.Error != "":
+			answeredAll = false
+		case q.CloserPeers == 0:
+			answeredAll = false
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s response has no closer peers", name))
+		case q.Duration > slowDHTResponse:
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s response took %s", name, q.Duration.Round(time.Millisecond)))
+		}
+		if q.Error == "" {
+			out.IsServer = true
+		}
+		if q.CloserPeersWithAddrs < q.CloserPeers {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s response has %d closer peers without addresses", name, q.CloserPeers-q.CloserPeersWithAddrs))
+		}
+	}
+	if !out.IsServer {
+		out.Warnings = append(out.Warnings, "the peer did not answer any DHT request: it is not a DHT server")
+	}
+
+	knownByNeighbors := out.NeighborsWithPeer > 0
+	if out.IsServer {
+		switch {
+		case out.Neighbors == 0:
+			out.Warnings = append(out.Warnings, "none of the closest peers to the peer responded: whether they have it in their routing table could not be verified")
+		case !knownByNeighbors:
+			out.Warnings = append(out.Warnings, fmt.Sprintf("the peer is not in the routing table of any of its %d closest peers: it may be unreachable and evicted by the network", out.Neighbors))
+		}
+	}
+	out.Useful = out.IsServer && answeredAll && knownByNeighbors
+}
+
+func dhtQuery(ctx context.Context, query func(context.Context) ([]*peer.AddrInfo, error)) DHTQueryOutput {
+	out := DHTQueryOutput{}
+
+	queryCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	start := time.Now()
+	closer, err := query(queryCtx)
+	out.Duration = time.Since(start)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	out.CloserPeers = len(closer)
+	for _, p := range closer {
+		if len(p.Addrs) > 0 {
+			out.CloserPeersWithAddrs++
+		}
+	}
+	return out
+}
+
+// peerInNeighborsRoutingTables sends a FIND_NODE request for the peer to each
+// of the closest peers to its key, and returns the number of them that
+// responded, and that returned the peer.
+func peerInNeighborsRoutingTables(ctx context.Context, d Kademlia, messenger *dhtpb.ProtocolMessenger, p peer.ID) (int, int) {
+	closestPeers, err := d.GetClosestPeers(ctx, string(p))
+	if err != nil {
+		return 0, 0
+	}
+
+	var neighbors, withPeer int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peerToQuery := range closestPeers {
+		if peerToQuery == p {
+			continue
+		}
+		wg.Add(1)
+		go func(peerToQuery peer.ID) {
+			defer wg.Done()
+
+			queryCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+			defer cancel()
+			closer, err := messenger.GetClosestPeers(queryCtx, peerToQuery, p)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			neighbors++
+			for _, c := range closer {
+				if c.ID == p {
+					withPeer++
+					return
+				}
+			}
+		}(peerToQuery)
+	}
+	wg.Wait()
+
+	return neighbors, withPeer
+}
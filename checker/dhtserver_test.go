@@ -0,0 +1,41 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHTServerEvaluate(t *testing.T) {
+	answered := DHTQueryOutput{CloserPeers: 2, CloserPeersWithAddrs: 2}
+	server := func(neighbors, neighborsWithPeer int) DHTServerCheckOutput {
+		return DHTServerCheckOutput{
+			FindNode:          answered,
+			GetProviders:      answered,
+			GetValue:          answered,
+			Neighbors:         neighbors,
+			NeighborsWithPeer: neighborsWithPeer,
+			Warnings:          []string{},
+		}
+	}
+
+	out := server(5, 3)
+	out.evaluate()
+	require.True(t, out.IsServer)
+	require.True(t, out.Useful)
+	require.Empty(t, out.Warnings)
+
+	out = server(5, 0)
+	out.evaluate()
+	require.False(t, out.Useful)
+	require.Len(t, out.Warnings, 1)
+	require.Contains(t, out.Warnings[0], "not in the routing table")
+
+	// without any neighbor responding, the routing table presence is unverified
+	out = server(0, 0)
+	out.evaluate()
+	require.True(t, out.IsServer)
+	require.False(t, out.Useful)
+	require.Len(t, out.Warnings, 1)
+	require.Contains(t, out.Warnings[0], "could not be verified")
+}
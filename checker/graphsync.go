@@ -0,0 +1,147 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	// importing so Graphsync can traverse the root block of common codecs
+	_ "github.com/ipld/go-codec-dagpb"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Transports used to check the data availability from a provider
+const (
+	TransportBitswap   = "bitswap"
+	TransportGraphsync = "graphsync"
+)
+
+// dataTransferExtensions are the Graphsync extensions used by the Filecoin
+// data transfer protocol to negotiate vouchers and payments
+var dataTransferExtensions = []graphsync.ExtensionName{
+	"fil/data-transfer",
+	"fil/data-transfer/1.1",
+	"fil/data-transfer/1.2",
+}
+
+// GraphsyncCheckOutput is the result of the Graphsync probe
+type GraphsyncCheckOutput struct {
+	Duration time.Duration
+	// Responded is true when the peer sent a response to the request
+	Responded bool
+	// BlockServed is true when the peer sent the root block of the CID
+	BlockServed bool
+	// Status is the last Graphsync response status sent by the peer
+	Status string
+	// VoucherRequired is true when the peer paused or rejected the request through
+	// the Filecoin data transfer protocol, which usually means a voucher or payment is required
+	VoucherRequired bool
+	Error           string
+}
+
+// graphsyncProbe requests the root block of the CID over Graphsync
+type graphsyncProbe struct{}
+
+func (graphsyncProbe) Name() string {
+	return TransportGraphsync
+}
+
+func (graphsyncProbe) Applies(t Target) bool {
+	return speaksGraphsync(t.Host, t.ID)
+}
+
+func (graphsyncProbe) Run(ctx context.Context, t Target, c cid.Cid, _ CheckOptions) ProbeResult {
+	return checkGraphsyncCID(ctx, t.Host, c, t.ID)
+}
+
+func (graphsyncProbe) DecodeResult(data []byte) (ProbeResult, error) {
+	var out GraphsyncCheckOutput
+	err := json.Unmarshal(data, &out)
+	return out, err
+}
+
+// DataAvailable returns whether the peer sent the root block of the CID
+func (out GraphsyncCheckOutput) DataAvailable() bool {
+	return out.BlockServed
+}
+
+// speaksGraphsync returns whether the peer announced Graphsync support via identify
+func speaksGraphsync(h host.Host, p peer.ID) bool {
+	protos, err := h.Peerstore().SupportsProtocols(p, gsnet.ProtocolGraphsync_2_0_0)
+	return err == nil && len(protos) > 0
+}
+
+// checkGraphsyncCID requests the root block of the CID from the peer over
+// Graphsync, without any data transfer voucher, and reports how the peer
+// handled the request. The host should already be connected to the peer.
+func checkGraphsyncCID(ctx context.Context, h host.Host, c cid.Cid, p peer.ID) GraphsyncCheckOutput {
+	log := Logger(ctx).With("cid", c, "peer", p)
+	log.Debug("Start of Graphsync check")
+	out := GraphsyncCheckOutput{}
+	start := time.Now()
+
+	gsCtx, cancel := context.WithTimeout(ctx, time.Second*15)
+	defer cancel()
+
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	gs := gsimpl.New(gsCtx, gsnet.NewFromLibp2pHost(h), lsys)
+
+	var mu sync.Mutex
+	gs.RegisterIncomingResponseHook(func(_ peer.ID, response graphsync.ResponseData, _ graphsync.IncomingResponseHookActions) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.Responded = true
+		out.Status = response.Status().String()
+		if response.Status() == graphsync.RequestPaused || response.Status() == graphsync.NotEnoughGas {
+			out.VoucherRequired = true
+		}
+		if response.Status().IsFailure() {
+			for _, ext := range dataTransferExtensions {
+				if _, ok := response.Extension(ext); ok {
+					out.VoucherRequired = true
+				}
+			}
+		}
+	})
+	gs.RegisterIncomingBlockHook(func(_ peer.ID, _ graphsync.ResponseData, block graphsync.BlockData, _ graphsync.IncomingBlockHookActions) {
+		if block.Link().(cidlink.Link).Cid.Equals(c) {
+			mu.Lock()
+			out.BlockServed = true
+			mu.Unlock()
+		}
+	})
+
+	progress, errs := gs.Request(gsCtx, p, cidlink.Link{Cid: c}, selectorparse.CommonSelector_MatchPoint)
+	for range progress {
+	}
+	var reqErr error
+	for err := range errs {
+		reqErr = errors.Join(reqErr, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reqErr != nil {
+		out.Error = reqErr.Error()
+	}
+	out.Duration = time.Since(start)
+	log.Debug("End of Graphsync check")
+	return out
+}
@@ -0,0 +1,271 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	findclient "github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transport protocols of the provider records, see
+// https://github.com/ipfs/specs/blob/main/IPIP/0484-routing-v1-filter-providers.md
+const (
+	ProtocolBitswap   = "transport-bitswap"
+	ProtocolGraphsync = "transport-graphsync-filecoinv1"
+)
+
+// ipniProvider is a provider record returned by the /routing/v1/providers
+// endpoint of an indexer, along with what it advertised.
+type ipniProvider struct {
+	peer.AddrInfo
+	// Protocols are the transport protocols advertised for the CID
+	Protocols []string
+	// Metadata maps each advertised protocol to its decoded metadata
+	Metadata map[string]IPNIMetadata
+}
+
+// IPNIMetadata is the metadata advertised to the indexer for a transport
+// protocol
+type IPNIMetadata struct {
+	// PieceCID, VerifiedDeal and FastRetrieval are advertised for transport-graphsync-filecoinv1
+	PieceCID      string
+	VerifiedDeal  bool
+	FastRetrieval bool
+}
+
+// findProvidersInIPNI returns up to count provider records of the CID from
+// the indexer. Unlike contentrouter.NewContentRoutingClient, the advertised
+// protocols and their metadata are kept.
+func findProvidersInIPNI(ctx context.Context, crClient *client.Client, c cid.Cid, count int) <-chan ipniProvider {
+	ch := make(chan ipniProvider)
+
+	ctx, span := tracer.Start(ctx, "findProvidersInIPNI", trace.WithAttributes(attribute.Stringer("cid", c)))
+	resultsIter, err := crClient.FindProviders(ctx, c)
+	if err != nil {
+		Logger(ctx).Warn("Error finding providers in IPNI", "cid", c, "err", err)
+		endSpan(span, err)
+		close(ch)
+		return ch
+	}
+
+	go func() {
+		defer span.End()
+		defer close(ch)
+		defer resultsIter.Close()
+		for sent := 0; sent < count && resultsIter.Next(); {
+			res := resultsIter.Val()
+			if res.Err != nil {
+				Logger(ctx).Warn("Error iterating providers in IPNI", "cid", c, "err", res.Err)
+				continue
+			}
+			prov, ok := ipniProviderFromRecord(res.Val)
+			if !ok {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- prov:
+				sent++
+			}
+		}
+	}()
+	return ch
+}
+
+func ipniProviderFromRecord(r types.Record) (ipniProvider, bool) {
+	pr, ok := r.(*types.PeerRecord)
+	if !ok || pr.ID == nil {
+		return ipniProvider{}, false
+	}
+
+	prov := ipniProvider{
+		AddrInfo:  peer.AddrInfo{ID: *pr.ID},
+		Protocols: pr.Protocols,
+		Metadata:  make(map[string]IPNIMetadata),
+	}
+	for _, a := range pr.Addrs {
+		prov.Addrs = append(prov.Addrs, a.Multiaddr)
+	}
+	// The metadata is returned in a field named after each protocol, or in a
+	// single Metadata field for all of them
+	fields := append(slices.Clone(pr.Protocols), "Metadata")
+	for _, f := range fields {
+		raw, ok := pr.Extra[f]
+		if !ok {
+			continue
+		}
+		decoded, err := decodeIPNIMetadata(raw)
+		if err != nil {
+			continue
+		}
+		for p, md := range decoded {
+			prov.Metadata[p] = md
+		}
+	}
+	return prov, true
+}
+
+// decodeIPNIMetadata decodes metadata as returned by the indexer: base64 of
+// the advertised binary metadata, where the metadata of each protocol is
+// prefixed with its multicodec as a varint.
+func decodeIPNIMetadata(raw json.RawMessage) (map[string]IPNIMetadata, error) {
+	var encoded []byte
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		return nil, err
+	}
+	md := metadata.Default.New()
+	if err := md.UnmarshalBinary(encoded); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]IPNIMetadata)
+	for _, code := range md.Protocols() {
+		var decoded IPNIMetadata
+		if gs, ok := md.Get(code).(*metadata.GraphsyncFilecoinV1); ok {
+			decoded.PieceCID = gs.PieceCID.String()
+			decoded.VerifiedDeal = gs.VerifiedDeal
+			decoded.FastRetrieval = gs.FastRetrieval
+		}
+		out[code.String()] = decoded
+	}
+	return out, nil
+}
+
+// protocolMismatches compares the protocols advertised by a provider with the
+// ones it was found to speak when connecting to it.
+func protocolMismatches(advertised []string, speaksBitswap, speaksGraphsync bool) []string {
+	mismatches := []string{}
+	if len(advertised) == 0 {
+		return mismatches
+	}
+	if slices.Contains(advertised, ProtocolBitswap) && !speaksBitswap {
+		mismatches = append(mismatches, fmt.Sprintf("advertised %s but doesn't speak Bitswap", ProtocolBitswap))
+	}
+	if slices.Contains(advertised, ProtocolGraphsync) && !speaksGraphsync {
+		mismatches = append(mismatches, fmt.Sprintf("advertised %s but doesn't speak Graphsync", ProtocolGraphsync))
+	}
+	if speaksBitswap && !slices.Contains(advertised, ProtocolBitswap) && !slices.Contains(advertised, "unknown") {
+		mismatches = append(mismatches, fmt.Sprintf("speaks Bitswap but didn't advertise %s", ProtocolBitswap))
+	}
+	return mismatches
+}
+
+// IPNIProviderCheckOutput describes the state of a provider in the indexer, and
+// how it compares to the head of the provider's own advertisement chain.
+type IPNIProviderCheckOutput struct {
+	// Found is true when the indexer knows the provider, i.e. it ingested at least one of its announcements
+	Found bool
+	// LastAdvertisement is the CID of the latest advertisement ingested by the indexer
+	LastAdvertisement string
+	// LastAdvertisementTime is when the indexer received the latest advertisement
+	LastAdvertisementTime *time.Time
+	// SinceLastAdvertisement is the time elapsed since LastAdvertisementTime
+	SinceLastAdvertisement time.Duration
+	// Lag is the number of advertisements left to ingest when the indexer is syncing with the provider
+	Lag int
+	// PublisherID and PublisherAddrs are where the indexer fetches the advertisements from
+	PublisherID    string
+	PublisherAddrs []string
+	// Inactive is true when the indexer didn't receive any update and the publisher doesn't respond to polls
+	Inactive bool
+	// LastIngestionError is the last error the indexer encountered when ingesting the provider's advertisements
+	LastIngestionError     string
+	LastIngestionErrorTime string
+	// HeadAdvertisement is the CID of the head of the advertisement chain, fetched from the publisher
+	HeadAdvertisement string
+	HeadError         string
+	// BehindHead is true when the latest advertisement ingested by the indexer isn't the publisher's head
+	BehindHead bool
+	Error      string
+}
+
+// ipniProviderCheck queries the indexer for the provider, and fetches the head
+// advertisement from its publisher to find out whether the indexer is behind.
+func ipniProviderCheck(ctx context.Context, h host.Host, transport http.RoundTripper, ipniURL string, p peer.AddrInfo) IPNIProviderCheckOutput {
+	ctx, span := tracer.Start(ctx, "ipniProviderCheck", trace.WithAttributes(
+		attribute.String("indexer", ipniURL),
+		attribute.Stringer("peer", p.ID),
+	))
+	defer span.End()
+
+	out := IPNIProviderCheckOutput{}
+
+	findClient, err := findclient.New(ipniURL, findclient.WithClient(&http.Client{Transport: transport}))
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	info, err := findClient.GetProvider(ctx, p.ID)
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) && apiErr.Status() == http.StatusNotFound {
+		info = nil
+	} else if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	// Without a publisher known by the indexer, try to fetch the head from the provider itself
+	publisher := p
+	if info != nil {
+		out.Found = true
+		if info.LastAdvertisement.Defined() {
+			out.LastAdvertisement = info.LastAdvertisement.String()
+		}
+		if t, err := time.Parse(time.RFC3339, info.LastAdvertisementTime); err == nil {
+			out.LastAdvertisementTime = &t
+			out.SinceLastAdvertisement = time.Since(t)
+		}
+		out.Lag = info.Lag
+		out.Inactive = info.Inactive
+		out.LastIngestionError = info.LastError
+		out.LastIngestionErrorTime = info.LastErrorTime
+		if info.Publisher != nil {
+			publisher = *info.Publisher
+			out.PublisherID = info.Publisher.ID.String()
+			for _, a := range info.Publisher.Addrs {
+				out.PublisherAddrs = append(out.PublisherAddrs, a.String())
+			}
+		}
+	}
+
+	head, err := fetchAdvertisementHead(ctx, h, publisher)
+	if err != nil {
+		out.HeadError = err.Error()
+		return out
+	}
+	out.HeadAdvertisement = head.String()
+	out.BehindHead = out.Found && out.LastAdvertisement != out.HeadAdvertisement
+
+	return out
+}
+
+// fetchAdvertisementHead fetches the head of the advertisement chain from the
+// publisher, over HTTP or libp2p.
+func fetchAdvertisementHead(ctx context.Context, h host.Host, publisher peer.AddrInfo) (cid.Cid, error) {
+	s := ipnisync.NewSync(cidlink.DefaultLinkSystem(), nil, ipnisync.ClientStreamHost(h), ipnisync.ClientHTTPTimeout(time.Second*10))
+	defer s.Close()
+
+	syncer, err := s.NewSyncer(publisher)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return syncer.GetHead(ctx)
+}
@@ -0,0 +1,103 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProvidersInIPNILimit(t *testing.T) {
+	var records []types.Record
+	for i := 0; i < 3; i++ {
+		id := test.RandPeerIDFatal(t)
+		records = append(records, &types.PeerRecord{Schema: types.SchemaPeer, ID: &id, Protocols: []string{ProtocolBitswap}})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]types.Record{"Providers": records})
+	}))
+	defer srv.Close()
+
+	crClient, err := client.New(srv.URL)
+	require.NoError(t, err)
+	c := cid.MustParse("bafkqaaa")
+
+	var found []peer.ID
+	for prov := range findProvidersInIPNI(context.Background(), crClient, c, 2) {
+		found = append(found, prov.ID)
+	}
+	require.Equal(t, []peer.ID{*records[0].(*types.PeerRecord).ID, *records[1].(*types.PeerRecord).ID}, found)
+}
+
+func TestIPNIProviderFromRecord(t *testing.T) {
+	// The metadata is the base64 of the binary metadata advertised by a
+	// Filecoin storage provider: the multicodec of the protocol as a varint,
+	// followed by the DAG-CBOR of PieceCID, VerifiedDeal and FastRetrieval
+	raw := `{
+		"Schema": "peer",
+		"ID": "12D3KooWQxH6UEBfWxNSH8Nk3xbGcwPpBMJuwfVeiMXQsNRtMHLY",
+		"Addrs": ["/ip4/1.2.3.4/tcp/24001"],
+		"Protocols": ["transport-graphsync-filecoinv1"],
+		"transport-graphsync-filecoinv1": "kBKjaFBpZWNlQ0lE2CpYKAABgeIDkiAgB35f3jXFCpMDpVAJ40mKTr7f85xCtxC3MNjsesevpj5sVmVyaWZpZWREZWFs9W1GYXN0UmV0cmlldmFs9Q=="
+	}`
+	var r types.PeerRecord
+	require.NoError(t, json.Unmarshal([]byte(raw), &r))
+
+	prov, ok := ipniProviderFromRecord(&r)
+	require.True(t, ok)
+	require.Equal(t, "12D3KooWQxH6UEBfWxNSH8Nk3xbGcwPpBMJuwfVeiMXQsNRtMHLY", prov.ID.String())
+	require.Len(t, prov.Addrs, 1)
+	require.Equal(t, []string{ProtocolGraphsync}, prov.Protocols)
+	require.Equal(t, map[string]IPNIMetadata{
+		ProtocolGraphsync: {
+			PieceCID:      "baga6ea4seaqao7s73y24kcutaosvacpdjgfe5pw76ooefnyqw4ynr3d2y6x2mpq",
+			VerifiedDeal:  true,
+			FastRetrieval: true,
+		},
+	}, prov.Metadata)
+
+	// The metadata of all the protocols may be returned in a single field
+	raw = `{
+		"Schema": "peer",
+		"ID": "12D3KooWQxH6UEBfWxNSH8Nk3xbGcwPpBMJuwfVeiMXQsNRtMHLY",
+		"Protocols": ["transport-bitswap", "transport-graphsync-filecoinv1"],
+		"Metadata": "gBKQEqNoUGllY2VDSUTYKlgoAAGB4gOSICAHfl/eNcUKkwOlUAnjSYpOvt/znEK3ELcw2Ox6x6+mPmxWZXJpZmllZERlYWz1bUZhc3RSZXRyaWV2YWz1"
+	}`
+	r = types.PeerRecord{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &r))
+	prov, ok = ipniProviderFromRecord(&r)
+	require.True(t, ok)
+	require.Len(t, prov.Metadata, 2)
+	require.Equal(t, IPNIMetadata{}, prov.Metadata[ProtocolBitswap])
+	require.True(t, prov.Metadata[ProtocolGraphsync].VerifiedDeal)
+
+	// Metadata that can't be decoded is left out
+	r.Extra["Metadata"] = json.RawMessage(`"not base64"`)
+	prov, ok = ipniProviderFromRecord(&r)
+	require.True(t, ok)
+	require.Empty(t, prov.Metadata)
+}
+
+func TestProtocolMismatches(t *testing.T) {
+	require.Empty(t, protocolMismatches(nil, false, false))
+	require.Empty(t, protocolMismatches([]string{ProtocolBitswap}, true, false))
+	require.Empty(t, protocolMismatches([]string{"unknown"}, true, false))
+
+	mismatches := protocolMismatches([]string{ProtocolBitswap, ProtocolGraphsync}, false, false)
+	require.Len(t, mismatches, 2)
+	require.Contains(t, mismatches[0], "doesn't speak Bitswap")
+	require.Contains(t, mismatches[1], "doesn't speak Graphsync")
+
+	mismatches = protocolMismatches([]string{ProtocolGraphsync}, true, true)
+	require.Len(t, mismatches, 1)
+	require.Contains(t, mismatches[0], "didn't advertise transport-bitswap")
+}
@@ -1,4 +1,4 @@
-package main
+package checker
 
 import (
 	"github.com/libp2p/go-libp2p/core/connmgr"
@@ -9,26 +9,28 @@ import (
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
-type privateAddrFilterConnectionGater struct{}
+// PrivateAddrFilterConnectionGater only allows connections to and from public
+// addresses, so that checks can't be used to probe private networks
+type PrivateAddrFilterConnectionGater struct{}
 
-var _ connmgr.ConnectionGater = (*privateAddrFilterConnectionGater)(nil)
+var _ connmgr.ConnectionGater = (*PrivateAddrFilterConnectionGater)(nil)
 
-func (f *privateAddrFilterConnectionGater) InterceptAddrDial(_ peer.ID, addr ma.Multiaddr) (allow bool) {
+func (f *PrivateAddrFilterConnectionGater) InterceptAddrDial(_ peer.ID, addr ma.Multiaddr) (allow bool) {
 	return manet.IsPublicAddr(addr)
 }
 
-func (f *privateAddrFilterConnectionGater) InterceptPeerDial(p peer.ID) (allow bool) {
+func (f *PrivateAddrFilterConnectionGater) InterceptPeerDial(p peer.ID) (allow bool) {
 	return true
 }
 
-func (f *privateAddrFilterConnectionGater) InterceptAccept(connAddr network.ConnMultiaddrs) (allow bool) {
+func (f *PrivateAddrFilterConnectionGater) InterceptAccept(connAddr network.ConnMultiaddrs) (allow bool) {
 	return manet.IsPublicAddr(connAddr.RemoteMultiaddr())
 }
 
-func (f *privateAddrFilterConnectionGater) InterceptSecured(_ network.Direction, _ peer.ID, connAddr network.ConnMultiaddrs) (allow bool) {
+func (f *PrivateAddrFilterConnectionGater) InterceptSecured(_ network.Direction, _ peer.ID, connAddr network.ConnMultiaddrs) (allow bool) {
 	return manet.IsPublicAddr(connAddr.RemoteMultiaddr())
 }
 
-func (f *privateAddrFilterConnectionGater) InterceptUpgraded(_ network.Conn) (allow bool, reason control.DisconnectReason) {
+func (f *PrivateAddrFilterConnectionGater) InterceptUpgraded(_ network.Conn) (allow bool, reason control.DisconnectReason) {
 	return true, 0
 }
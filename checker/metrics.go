@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// checkerMetrics are the Prometheus metrics recorded while running the checks
+type checkerMetrics struct {
+	bitswapConnectDuration       prometheus.Histogram
+	bitswapFirstResponseDuration prometheus.Histogram
+	bitswapBlockDuration         prometheus.Histogram
+	bitswapBlockSize             prometheus.Histogram
+	bitswapResults               *prometheus.CounterVec
+	testHostPoolIdle             prometheus.Gauge
+	testHostPoolInUse            prometheus.Gauge
+	testHostsCreated             prometheus.Counter
+	testHostsReused              prometheus.Counter
+	ipniRequestDuration          *prometheus.HistogramVec
+}
+
+func newCheckerMetrics(reg prometheus.Registerer) *checkerMetrics {
+	m := &checkerMetrics{
+		bitswapConnectDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bitswap_check_connect_duration_seconds",
+			Help:    "Time taken to connect to a peer and open a Bitswap stream",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		}),
+		bitswapFirstResponseDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bitswap_check_first_response_duration_seconds",
+			Help:    "Time between sending a want and the first HAVE, DONT_HAVE or block response",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
+		bitswapBlockDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bitswap_check_block_duration_seconds",
+			Help:    "Time between sending a want and receiving the block",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
+		bitswapBlockSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bitswap_check_block_size_bytes",
+			Help:    "Size of the blocks received during Bitswap checks",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		}),
+		bitswapResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bitswap_check_results_total",
+			Help: "Total number of Bitswap checks by result: found, not_found (the peer responded without the block) or no_response",
+		}, []string{"result"}),
+		testHostPoolIdle: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "test_host_pool_idle",
+			Help: "Number of idle test hosts in the pool",
+		}),
+		testHostPoolInUse: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "test_host_pool_in_use",
+			Help: "Number of test hosts used by running checks",
+		}),
+		testHostsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_host_pool_created_total",
+			Help: "Total number of test hosts created",
+		}),
+		testHostsReused: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_host_pool_reused_total",
+			Help: "Total number of checks that ran from a recycled or pre-created test host",
+		}),
+		ipniRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ipni_request_duration_seconds",
+			Help:    "Time taken by IPNI indexers to respond to requests, until the response headers",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		}, []string{"indexer"}),
+	}
+
+	reg.MustRegister(
+		m.bitswapConnectDuration,
+		m.bitswapFirstResponseDuration,
+		m.bitswapBlockDuration,
+		m.bitswapBlockSize,
+		m.bitswapResults,
+		m.testHostPoolIdle,
+		m.testHostPoolInUse,
+		m.testHostsCreated,
+		m.testHostsReused,
+		m.ipniRequestDuration,
+	)
+
+	return m
+}
+
+// observeProbe records the result of a probe
+func (m *checkerMetrics) observeProbe(res ProbeResult) {
+	if out, ok := res.(BitswapCheckOutput); ok {
+		m.observeBitswapCheck(out)
+	}
+}
+
+// observeBitswapCheck records the timing of a Bitswap check. It is a no-op on
+// a nil receiver, so that checkers created without metrics can run checks.
+func (m *checkerMetrics) observeBitswapCheck(out BitswapCheckOutput) {
+	if m == nil {
+		return
+	}
+	if out.ConnectDuration > 0 {
+		m.bitswapConnectDuration.Observe(out.ConnectDuration.Seconds())
+	}
+	if out.Responded && out.FirstResponseDuration > 0 {
+		m.bitswapFirstResponseDuration.Observe(out.FirstResponseDuration.Seconds())
+	}
+	if out.BlockSize > 0 {
+		m.bitswapBlockDuration.Observe(out.BlockDuration.Seconds())
+		m.bitswapBlockSize.Observe(float64(out.BlockSize))
+	}
+	switch {
+	case out.Found:
+		m.bitswapResults.WithLabelValues("found").Inc()
+	case out.Responded:
+		m.bitswapResults.WithLabelValues("not_found").Inc()
+	default:
+		m.bitswapResults.WithLabelValues("no_response").Inc()
+	}
+}
+
+func (m *checkerMetrics) setTestHostPool(idle, inUse int) {
+	if m == nil {
+		return
+	}
+	m.testHostPoolIdle.Set(float64(idle))
+	m.testHostPoolInUse.Set(float64(inUse))
+}
+
+func (m *checkerMetrics) testHostCreated() {
+	if m == nil {
+		return
+	}
+	m.testHostsCreated.Inc()
+}
+
+func (m *checkerMetrics) testHostReused() {
+	if m == nil {
+		return
+	}
+	m.testHostsReused.Inc()
+}
+
+func (m *checkerMetrics) observeIPNIRequest(u *url.URL, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.ipniRequestDuration.WithLabelValues(ipniIndexerLabel(u)).Observe(d.Seconds())
+}
+
+// ipniMetricsTransport records the latency of the requests to IPNI indexers
+type ipniMetricsTransport struct {
+	http.RoundTripper
+	metrics *checkerMetrics
+}
+
+func (t ipniMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	t.metrics.observeIPNIRequest(req.URL, time.Since(start))
+	return resp, err
+}
+
+// ipniIndexerLabel returns the indexer label of a request: the default
+// indexer, or other for the indexers requested by the checks, which would
+// otherwise let anyone create unlimited series
+func ipniIndexerLabel(u *url.URL) string {
+	if origin := u.Scheme + "://" + u.Host; origin == DefaultIndexerURL {
+		return origin
+	}
+	return "other"
+}
@@ -0,0 +1,55 @@
+package checker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPNIIndexerLabel(t *testing.T) {
+	for rawURL, label := range map[string]string{
+		DefaultIndexerURL + "/routing/v1/providers/bafkqaaa": DefaultIndexerURL,
+		"https://indexer.example.com/multihash/abc":          "other",
+		"http://cid.contact/providers":                       "other",
+	} {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		require.Equal(t, label, ipniIndexerLabel(u), rawURL)
+	}
+}
+
+func TestIPNIMetricsTransportPerChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// each checker records the requests of its own transport
+	regA, regB := prometheus.NewRegistry(), prometheus.NewRegistry()
+	client := &http.Client{Transport: newIPNITransport(newCheckerMetrics(regA))}
+	_ = newCheckerMetrics(regB)
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	requests := func(reg *prometheus.Registry) uint64 {
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		for _, f := range families {
+			if f.GetName() == "ipni_request_duration_seconds" {
+				return f.GetMetric()[0].GetHistogram().GetSampleCount()
+			}
+		}
+		return 0
+	}
+	require.EqualValues(t, 1, requests(regA))
+	require.EqualValues(t, 0, requests(regB))
+
+	// checkers without metrics don't record anything
+	client = &http.Client{Transport: newIPNITransport(nil)}
+	resp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
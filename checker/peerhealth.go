@@ -0,0 +1,194 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PeerHealthOutput is the result of CheckPeerHealth
+type PeerHealthOutput struct {
+	ConnectionError  string
+	PeerFoundInDHT   map[string]int
+	ConnectionMaddrs []string
+	// PingRTT is the round trip time of a libp2p ping over a direct connection
+	PingRTT   time.Duration
+	PingError string
+	// AgentVersion, Protocols and ListenAddrs are reported by the peer through identify
+	AgentVersion string
+	Protocols    []string
+	ListenAddrs  []string
+	// AddrDials is the result of dialing each public address of the peer separately
+	AddrDials []AddrDialOutput
+	// DHTServer is the result of the DHT server check, when the connection succeeded
+	DHTServer *DHTServerCheckOutput
+	// CachedAt is when the check ran, set by the ipfs-check server as results may be served from its cache
+	CachedAt time.Time
+}
+
+// AddrDialOutput is the result of dialing a single address of a peer
+type AddrDialOutput struct {
+	Addr      string
+	Transport string
+	Duration  time.Duration
+	Error     string
+}
+
+// CheckPeerHealth checks the connectivity of a peer, either with just
+// /p2p/<peer ID> or a specific multiaddr, without checking any CID.
+func (chk *Checker) CheckPeerHealth(ctx context.Context, ma multiaddr.Multiaddr) (*PeerHealthOutput, error) {
+	ai, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := tracer.Start(ctx, "runPeerHealthCheck", trace.WithAttributes(attribute.Stringer("peer", ai.ID)))
+	defer span.End()
+
+	addrMap, peerAddrDHTErr := peerAddrsInDHT(ctx, chk.dht, chk.dhtMessenger, ai.ID)
+
+	out := &PeerHealthOutput{
+		PeerFoundInDHT: addrMap,
+		AddrDials:      []AddrDialOutput{},
+	}
+
+	// If peerID given,but no addresses check the DHT
+	if len(ai.Addrs) == 0 {
+		if peerAddrDHTErr != nil {
+			// PeerID is not resolvable via the DHT
+			out.ConnectionError = peerAddrDHTErr.Error()
+			return out, nil
+		}
+		for a := range addrMap {
+			ma, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				Logger(ctx).Warn("Error parsing multiaddr", "multiaddr", a, "err", err)
+				continue
+			}
+			ai.Addrs = append(ai.Addrs, ma)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		out.AddrDials = chk.dialEachAddr(ctx, *ai)
+		wg.Done()
+	}()
+	defer wg.Wait()
+
+	testHost, err := chk.newTestHost()
+	if err != nil {
+		return nil, fmt.Errorf("server error: %w", err)
+	}
+	defer testHost.Close()
+
+	// Test Is the target connectable
+	dialCtx, dialCancel := context.WithTimeout(ctx, time.Second*120)
+	defer dialCancel()
+	if err := testHost.Connect(dialCtx, *ai); err != nil {
+		out.ConnectionError = err.Error()
+		return out, nil
+	}
+
+	// Ping opens a stream, which forces NAT hole punching when connected through a relay
+	res := <-ping.Ping(dialCtx, testHost, ai.ID)
+	if res.Error != nil {
+		out.PingError = res.Error.Error()
+	} else {
+		out.PingRTT = res.RTT
+	}
+
+	// Get all connection maddrs to the peer (in case we hole punched, there will usually be two: limited relay and direct)
+	for _, c := range testHost.Network().ConnsToPeer(ai.ID) {
+		out.ConnectionMaddrs = append(out.ConnectionMaddrs, c.RemoteMultiaddr().String())
+	}
+
+	if av, err := testHost.Peerstore().Get(ai.ID, "AgentVersion"); err == nil {
+		out.AgentVersion, _ = av.(string)
+	}
+	if protos, err := testHost.Peerstore().GetProtocols(ai.ID); err == nil {
+		for _, p := range protos {
+			out.Protocols = append(out.Protocols, string(p))
+		}
+		slices.Sort(out.Protocols)
+	}
+	for _, a := range testHost.Peerstore().Addrs(ai.ID) {
+		out.ListenAddrs = append(out.ListenAddrs, a.String())
+	}
+
+	dhtServer := dhtServerCheck(ctx, chk, testHost, *ai)
+	out.DHTServer = &dhtServer
+
+	return out, nil
+}
+
+// dialEachAddr dials each public address of the peer with a separate host, so
+// that every transport is tested independently.
+func (chk *Checker) dialEachAddr(ctx context.Context, ai peer.AddrInfo) []AddrDialOutput {
+	var addrs []multiaddr.Multiaddr
+	for _, a := range ai.Addrs {
+		if manet.IsPublicAddr(a) && !slices.ContainsFunc(addrs, a.Equal) {
+			addrs = append(addrs, a)
+		}
+	}
+
+	out := make([]AddrDialOutput, len(addrs))
+	var wg sync.WaitGroup
+	for i, a := range addrs {
+		wg.Add(1)
+		go func(i int, a multiaddr.Multiaddr) {
+			defer wg.Done()
+			out[i] = AddrDialOutput{
+				Addr:      a.String(),
+				Transport: AddrTransport(a),
+			}
+
+			h, err := chk.newTestHost()
+			if err != nil {
+				out[i].Error = err.Error()
+				return
+			}
+			defer h.Close()
+
+			dialCtx, cancel := context.WithTimeout(ctx, time.Second*15)
+			defer cancel()
+			start := time.Now()
+			if err := h.Connect(dialCtx, peer.AddrInfo{ID: ai.ID, Addrs: []multiaddr.Multiaddr{a}}); err != nil {
+				out[i].Error = err.Error()
+				return
+			}
+			out[i].Duration = time.Since(start)
+		}(i, a)
+	}
+	wg.Wait()
+	return out
+}
+
+// AddrTransport returns the name of the transport used to dial the address
+func AddrTransport(a multiaddr.Multiaddr) string {
+	// ordered from the outermost protocol, as e.g. webtransport runs on quic-v1
+	for _, code := range []int{
+		multiaddr.P_CIRCUIT,
+		multiaddr.P_WEBRTC_DIRECT,
+		multiaddr.P_WEBTRANSPORT,
+		multiaddr.P_QUIC_V1,
+		multiaddr.P_QUIC,
+		multiaddr.P_WSS,
+		multiaddr.P_WS,
+		multiaddr.P_TCP,
+	} {
+		if _, err := a.ValueForProtocol(code); err == nil {
+			return multiaddr.ProtocolWithCode(code).Name
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,23 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrTransport(t *testing.T) {
+	for addr, transport := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":                      "tcp",
+		"/ip4/1.2.3.4/tcp/4001/ws":                   "ws",
+		"/ip4/1.2.3.4/udp/4001/quic-v1":              "quic-v1",
+		"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport": "webtransport",
+		"/ip4/1.2.3.4/udp/4001/webrtc-direct":        "webrtc-direct",
+		"/ip4/1.2.3.4/tcp/4001/p2p/12D3KooWQxH6UEBfWxNSH8Nk3xbGcwPpBMJuwfVeiMXQsNRtMHLY/p2p-circuit": "p2p-circuit",
+	} {
+		ma, err := multiaddr.NewMultiaddr(addr)
+		require.NoError(t, err)
+		require.Equal(t, transport, AddrTransport(ma), addr)
+	}
+}
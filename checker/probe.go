@@ -0,0 +1,197 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Probe checks whether a peer serves a CID over a protocol, from a test host
+// connected to the peer. Probes are registered with RegisterProbe, and
+// selected by name with CheckOptions.Probes.
+type Probe interface {
+	// Name identifies the probe in CheckOptions.Probes and in the results
+	Name() string
+	// Applies returns whether the probe can run against the peer, e.g.
+	// whether the peer speaks the protocol of the probe
+	Applies(t Target) bool
+	// Run checks whether the peer serves the CID
+	Run(ctx context.Context, t Target, c cid.Cid, opts CheckOptions) ProbeResult
+	// DecodeResult decodes a result of Run encoded as JSON, e.g. from a cache
+	DecodeResult(data []byte) (ProbeResult, error)
+}
+
+// ProbeResult is the result of a probe, e.g. BitswapCheckOutput. Results
+// are encoded as JSON in the Probes of the check results.
+type ProbeResult interface {
+	// DataAvailable returns whether the peer served the CID
+	DataAvailable() bool
+}
+
+// ProbeResults maps the name of each probe that ran to its result
+type ProbeResults map[string]ProbeResult
+
+// UnmarshalJSON decodes the results with their probes, the results of probes
+// that aren't registered are skipped
+func (r *ProbeResults) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*r = nil
+		return nil
+	}
+	*r = make(ProbeResults, len(raw))
+	for name, msg := range raw {
+		p, ok := LookupProbe(name)
+		if !ok {
+			continue
+		}
+		res, err := p.DecodeResult(msg)
+		if err != nil {
+			return fmt.Errorf("decoding the result of probe %q: %w", name, err)
+		}
+		(*r)[name] = res
+	}
+	return nil
+}
+
+// DataAvailable returns whether any of the probes found the data
+func (r ProbeResults) DataAvailable() bool {
+	for _, res := range r {
+		if res.DataAvailable() {
+			return true
+		}
+	}
+	return false
+}
+
+// Target is the peer a probe runs against
+type Target struct {
+	// Host is the test host, connected to the peer
+	Host host.Host
+	peer.AddrInfo
+	// ConnectDuration is the time taken to connect to the peer
+	ConnectDuration time.Duration
+}
+
+var (
+	probesMu sync.RWMutex
+	// probes are the registered probes, in registration order
+	probes []Probe
+)
+
+func init() {
+	// Bitswap first, as it is the transport of most peers. Filecoin storage
+	// providers often serve data over Graphsync only.
+	RegisterProbe(bitswapProbe{})
+	RegisterProbe(graphsyncProbe{})
+}
+
+// RegisterProbe makes a probe available to the checks. It panics if a probe
+// with the same name is already registered.
+func RegisterProbe(p Probe) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	for _, registered := range probes {
+		if registered.Name() == p.Name() {
+			panic(fmt.Sprintf("checker: probe %q registered twice", p.Name()))
+		}
+	}
+	probes = append(probes, p)
+}
+
+// ProbeNames returns the names of the registered probes, in registration order
+func ProbeNames() []string {
+	probesMu.RLock()
+	defer probesMu.RUnlock()
+	names := make([]string, 0, len(probes))
+	for _, p := range probes {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// LookupProbe returns the registered probe with the name
+func LookupProbe(name string) (Probe, bool) {
+	probesMu.RLock()
+	defer probesMu.RUnlock()
+	for _, p := range probes {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// selectProbes returns the probes with the given names, or all the
+// registered probes when no names are given
+func selectProbes(names []string) ([]Probe, error) {
+	if len(names) == 0 {
+		probesMu.RLock()
+		defer probesMu.RUnlock()
+		return append([]Probe(nil), probes...), nil
+	}
+	selected := make([]Probe, 0, len(names))
+	for _, name := range names {
+		p, ok := LookupProbe(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown probe %q, expected one of: %s", name, strings.Join(ProbeNames(), ", "))
+		}
+		selected = append(selected, p)
+	}
+	return selected, nil
+}
+
+// runProbes runs the probes that apply to the peer, and returns their
+// results by probe name. When no probes were requested, only the first
+// registered probe that applies runs, e.g. Graphsync only runs for peers that
+// don't speak Bitswap.
+func (chk *Checker) runProbes(ctx context.Context, selected []Probe, t Target, c cid.Cid, opts CheckOptions) ProbeResults {
+	results := make(ProbeResults, len(selected))
+	for _, p := range selected {
+		if !p.Applies(t) {
+			continue
+		}
+		probeCtx, span := tracer.Start(ctx, "Probe", trace.WithAttributes(attribute.String("probe", p.Name())))
+		res := p.Run(probeCtx, t, c, opts)
+		span.SetAttributes(attribute.Bool("dataAvailable", res.DataAvailable()))
+		span.End()
+
+		results[p.Name()] = res
+		chk.metrics.observeProbe(res)
+		if len(opts.Probes) == 0 {
+			break
+		}
+	}
+	return results
+}
+
+// errNoProbeApplies is the connection error of peers that don't speak the
+// protocol of any of the probes
+func errNoProbeApplies(selected []Probe) error {
+	names := make([]string, 0, len(selected))
+	for _, p := range selected {
+		names = append(names, p.Name())
+	}
+	return fmt.Errorf("protocols not supported by the peer: %s", strings.Join(names, ", "))
+}
+
+// connect connects the test host to the peer
+func connect(ctx context.Context, h host.Host, ai peer.AddrInfo) (Target, error) {
+	ctx, span := tracer.Start(ctx, "Connect", trace.WithAttributes(attribute.Stringer("peer", ai.ID)))
+	start := time.Now()
+	err := h.Connect(ctx, ai)
+	endSpan(span, err)
+	return Target{Host: h, AddrInfo: ai, ConnectDuration: time.Since(start)}, err
+}
@@ -0,0 +1,76 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProbe applies to peers depending on applies, and records whether it ran
+type fakeProbe struct {
+	name    string
+	applies bool
+	ran     *bool
+}
+
+func (p fakeProbe) Name() string                             { return p.name }
+func (p fakeProbe) Applies(Target) bool                      { return p.applies }
+func (p fakeProbe) DecodeResult([]byte) (ProbeResult, error) { return BitswapCheckOutput{}, nil }
+func (p fakeProbe) Run(context.Context, Target, cid.Cid, CheckOptions) ProbeResult {
+	*p.ran = true
+	return BitswapCheckOutput{Found: true}
+}
+
+func TestSelectProbes(t *testing.T) {
+	require.Equal(t, []string{TransportBitswap, TransportGraphsync}, ProbeNames())
+
+	selected, err := selectProbes(nil)
+	require.NoError(t, err)
+	require.Len(t, selected, 2)
+
+	selected, err = selectProbes([]string{TransportGraphsync})
+	require.NoError(t, err)
+	require.Equal(t, []Probe{graphsyncProbe{}}, selected)
+
+	_, err = selectProbes([]string{"http"})
+	require.ErrorContains(t, err, `unknown probe "http"`)
+}
+
+func TestRunProbes(t *testing.T) {
+	var skippedRan, firstRan, secondRan bool
+	selected := []Probe{
+		fakeProbe{name: "skipped", ran: &skippedRan},
+		fakeProbe{name: "first", applies: true, ran: &firstRan},
+		fakeProbe{name: "second", applies: true, ran: &secondRan},
+	}
+	chk := &Checker{}
+
+	// by default, only the first probe that applies runs
+	results := chk.runProbes(context.Background(), selected, Target{}, cid.Undef, CheckOptions{})
+	require.Equal(t, ProbeResults{"first": BitswapCheckOutput{Found: true}}, results)
+	require.False(t, skippedRan)
+	require.False(t, secondRan)
+
+	// requested probes all run when they apply
+	results = chk.runProbes(context.Background(), selected, Target{}, cid.Undef, CheckOptions{Probes: []string{"skipped", "first", "second"}})
+	require.Len(t, results, 2)
+	require.True(t, secondRan)
+	require.False(t, skippedRan)
+}
+
+func TestProbeResultsJSON(t *testing.T) {
+	results := ProbeResults{
+		TransportBitswap:   BitswapCheckOutput{Found: true, Protocol: "/ipfs/bitswap/1.2.0"},
+		TransportGraphsync: GraphsyncCheckOutput{BlockServed: true},
+	}
+	data, err := json.Marshal(results)
+	require.NoError(t, err)
+
+	var decoded ProbeResults
+	require.NoError(t, json.Unmarshal(append(data[:len(data)-1], []byte(`,"unregistered":{}}`)...), &decoded))
+	require.Equal(t, results, decoded)
+	require.True(t, decoded[TransportGraphsync].DataAvailable())
+}
@@ -0,0 +1,260 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	dhtpb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ProviderRecordReplication describes how well the provider record of a peer
+// for a given CID is replicated across the DHT peers closest to the CID's key.
+type ProviderRecordReplication struct {
+	// ClosestPeers is the number of peers closest to the CID's key that were queried
+	ClosestPeers int
+	// PeersWithRecord lists the queried peers that returned a provider record for the peer
+	PeersWithRecord []string
+	// PeersWithoutRecord lists the queried peers that responded without a provider record for the peer
+	PeersWithoutRecord []string
+	// PeersFailed maps the queried peers that did not respond to the error encountered
+	PeersFailed map[string]string
+	// Addrs maps the addresses returned with the provider record to the number of peers returning them
+	Addrs map[string]int
+	Error string
+}
+
+// providerRecordReplicationInDHT sends a GET_PROVIDERS request for the CID to
+// each of the closest DHT peers to its key, and reports which of them hold a
+// provider record for the given peer.
+func providerRecordReplicationInDHT(ctx context.Context, d Kademlia, messenger *dhtpb.ProtocolMessenger, c cid.Cid, p peer.ID) ProviderRecordReplication {
+	out := ProviderRecordReplication{
+		PeersWithRecord:    []string{},
+		PeersWithoutRecord: []string{},
+		PeersFailed:        make(map[string]string),
+		Addrs:              make(map[string]int),
+	}
+
+	closestPeers, err := d.GetClosestPeers(ctx, string(c.Hash()))
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.ClosestPeers = len(closestPeers)
+
+	// Unlike peerAddrsInDHT, we don't use execOnMany here: it stops waiting once
+	// enough peers responded, which would skew the replication count.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, peerToQuery := range closestPeers {
+		wg.Add(1)
+		go func(peerToQuery peer.ID) {
+			defer wg.Done()
+
+			queryCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+			defer cancel()
+			provs, _, err := messenger.GetProviders(queryCtx, peerToQuery, c.Hash())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				out.PeersFailed[peerToQuery.String()] = err.Error()
+				return
+			}
+			for _, prov := range provs {
+				if prov.ID == p {
+					out.PeersWithRecord = append(out.PeersWithRecord, peerToQuery.String())
+					for _, addr := range prov.Addrs {
+						out.Addrs[addr.String()]++
+					}
+					return
+				}
+			}
+			out.PeersWithoutRecord = append(out.PeersWithoutRecord, peerToQuery.String())
+		}(peerToQuery)
+	}
+	wg.Wait()
+
+	slices.Sort(out.PeersWithRecord)
+	slices.Sort(out.PeersWithoutRecord)
+
+	if out.ClosestPeers > 0 && len(out.PeersFailed) == out.ClosestPeers {
+		out.Error = fmt.Sprintf("none of the %d closest peers responded", out.ClosestPeers)
+	}
+
+	return out
+}
+
+// ProviderRecordObservation is the replication of a provider record seen by a
+// single check.
+type ProviderRecordObservation struct {
+	Time            time.Time
+	ClosestPeers    int
+	PeersWithRecord int
+
+	withRecord    []string
+	withoutRecord []string
+}
+
+// ProviderRecordFreshness is an estimate of when a provider record was last
+// (re)published, based on the observations of previous checks of the same CID
+// and peer.
+type ProviderRecordFreshness struct {
+	// Observations of the provider record replication, oldest first
+	Observations []ProviderRecordObservation
+	// LastPublishedAfter is the earliest time at which the record could have last been (re)published
+	LastPublishedAfter *time.Time
+	// LastRepublishBefore is set when a republish was detected between two observations, and is the time of the later one
+	LastRepublishBefore *time.Time
+	// ExpiresNotBefore is the earliest time at which the record expires if the peer stops reproviding
+	ExpiresNotBefore *time.Time
+	Warnings         []string
+}
+
+const (
+	// maximum number of observations kept per CID and peer
+	maxProviderRecordObservations = 24
+	// maximum number of CID and peer pairs for which observations are kept
+	maxProviderRecordObservationKeys = 10_000
+)
+
+// providerRecordObservations stores the replication observations of provider
+// records across checks, so that subsequent checks can show a trend. The zero
+// value is ready to use.
+type providerRecordObservations struct {
+	cache *lru.Cache[string, []ProviderRecordObservation]
+	mu    sync.Mutex
+}
+
+// add records a new observation for the CID and peer, and returns all the
+// observations for them, oldest first.
+func (o *providerRecordObservations) add(c cid.Cid, p peer.ID, replication ProviderRecordReplication, now time.Time) []ProviderRecordObservation {
+	key := string(c.Hash()) + "/" + string(p)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.cache == nil {
+		o.cache, _ = lru.New[string, []ProviderRecordObservation](maxProviderRecordObservationKeys)
+	}
+
+	observations, _ := o.cache.Get(key)
+	// Observations older than twice the record validity can't tell anything about the current record
+	for len(observations) > 0 && now.Sub(observations[0].Time) > 2*providers.ProvideValidity {
+		observations = observations[1:]
+	}
+	if replication.Error == "" {
+		observations = append(observations, ProviderRecordObservation{
+			Time:            now,
+			ClosestPeers:    replication.ClosestPeers,
+			PeersWithRecord: len(replication.PeersWithRecord),
+			withRecord:      replication.PeersWithRecord,
+			withoutRecord:   replication.PeersWithoutRecord,
+		})
+	}
+	if len(observations) > maxProviderRecordObservations {
+		observations = observations[len(observations)-maxProviderRecordObservations:]
+	}
+	observations = slices.Clip(observations)
+	o.cache.Add(key, observations)
+
+	return observations
+}
+
+// providerRecordFreshness estimates when a provider record was last
+// (re)published from its observations, oldest first.
+//
+// A republish can only be detected when a peer that didn't hold the record in
+// an observation holds it in the next one, so republishes to peers that
+// already held the record go unnoticed. The estimate is therefore expressed as
+// lower bounds, relying on the record validity of the DHT.
+func providerRecordFreshness(observations []ProviderRecordObservation) ProviderRecordFreshness {
+	out := ProviderRecordFreshness{
+		Observations: observations,
+		Warnings:     []string{},
+	}
+	if len(observations) == 0 {
+		return out
+	}
+
+	latest := observations[len(observations)-1]
+	if latest.PeersWithRecord == 0 {
+		for _, obs := range observations[:len(observations)-1] {
+			if obs.PeersWithRecord > 0 {
+				out.Warnings = append(out.Warnings, fmt.Sprintf("provider record was held by %d of the %d closest peers at %s but is now held by none: it expired or the peer stopped providing", obs.PeersWithRecord, obs.ClosestPeers, obs.Time.Format(time.RFC3339)))
+				break
+			}
+		}
+		return out
+	}
+
+	// A record seen in the latest observation must have been published within the record validity
+	lastPublishedAfter := latest.Time.Add(-providers.ProvideValidity)
+
+	// The latest republish is the last time a peer without the record gained it
+	for i := len(observations) - 1; i > 0; i-- {
+		prev, cur := observations[i-1], observations[i]
+		if cur.PeersWithRecord == 0 || !gainedRecord(prev, cur) {
+			continue
+		}
+		republishBefore := cur.Time
+		out.LastRepublishBefore = &republishBefore
+		if prev.Time.After(lastPublishedAfter) {
+			lastPublishedAfter = prev.Time
+		}
+		break
+	}
+	out.LastPublishedAfter = &lastPublishedAfter
+	expiresNotBefore := lastPublishedAfter.Add(providers.ProvideValidity)
+	out.ExpiresNotBefore = &expiresNotBefore
+
+	if 2*latest.PeersWithRecord < latest.ClosestPeers {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("provider record is held by only %d of the %d closest peers", latest.PeersWithRecord, latest.ClosestPeers))
+	}
+
+	if len(observations) > 1 {
+		prev := observations[len(observations)-2]
+		if prev.ClosestPeers > 0 && replicationRatio(latest) < replicationRatio(prev) {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("provider record replication is decaying: held by %d of the %d closest peers, down from %d of %d at %s", latest.PeersWithRecord, latest.ClosestPeers, prev.PeersWithRecord, prev.ClosestPeers, prev.Time.Format(time.RFC3339)))
+		}
+	}
+
+	// Only warn when the observations span a full reprovide interval, as otherwise a republish may not have been due yet
+	since := observations[0].Time
+	if out.LastRepublishBefore != nil {
+		since = *out.LastRepublishBefore
+	}
+	if latest.Time.Sub(since) > provider.DefaultReproviderInterval && replicationRatio(latest) < 1 {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("no republish observed since %s while replication is incomplete: the peer may have stopped reproviding, and the record may expire as soon as %s", since.Format(time.RFC3339), expiresNotBefore.Format(time.RFC3339)))
+	}
+
+	return out
+}
+
+// gainedRecord returns whether a peer that responded without the record in
+// prev holds it in cur, or whether no peer held it in prev.
+func gainedRecord(prev, cur ProviderRecordObservation) bool {
+	if prev.PeersWithRecord == 0 {
+		return true
+	}
+	for _, p := range cur.withRecord {
+		if slices.Contains(prev.withoutRecord, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func replicationRatio(obs ProviderRecordObservation) float64 {
+	if obs.ClosestPeers == 0 {
+		return 0
+	}
+	return float64(obs.PeersWithRecord) / float64(obs.ClosestPeers)
+}
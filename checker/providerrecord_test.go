@@ -0,0 +1,69 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderRecordFreshness(t *testing.T) {
+	start := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	observation := func(at time.Duration, withRecord, withoutRecord []string) ProviderRecordObservation {
+		return ProviderRecordObservation{
+			Time:            start.Add(at),
+			ClosestPeers:    len(withRecord) + len(withoutRecord),
+			PeersWithRecord: len(withRecord),
+			withRecord:      withRecord,
+			withoutRecord:   withoutRecord,
+		}
+	}
+
+	t.Run("single observation only bounds the publish time with the record validity", func(t *testing.T) {
+		out := providerRecordFreshness([]ProviderRecordObservation{
+			observation(0, []string{"a", "b"}, []string{"c"}),
+		})
+
+		require.Nil(t, out.LastRepublishBefore)
+		require.Equal(t, start.Add(-providers.ProvideValidity), *out.LastPublishedAfter)
+		require.Equal(t, start, *out.ExpiresNotBefore)
+		require.Empty(t, out.Warnings)
+	})
+
+	t.Run("peer gaining the record is detected as a republish", func(t *testing.T) {
+		out := providerRecordFreshness([]ProviderRecordObservation{
+			observation(0, []string{"a"}, []string{"b", "c"}),
+			observation(time.Hour, []string{"a", "b", "c"}, []string{}),
+		})
+
+		require.Equal(t, start.Add(time.Hour), *out.LastRepublishBefore)
+		require.Equal(t, start, *out.LastPublishedAfter)
+		require.Equal(t, start.Add(providers.ProvideValidity), *out.ExpiresNotBefore)
+	})
+
+	t.Run("decaying replication without republish is reported", func(t *testing.T) {
+		out := providerRecordFreshness([]ProviderRecordObservation{
+			observation(0, []string{"a", "b", "c", "d"}, []string{}),
+			observation(23*time.Hour, []string{"a"}, []string{"b", "c", "d"}),
+		})
+
+		require.Nil(t, out.LastRepublishBefore)
+		require.Len(t, out.Warnings, 3)
+		require.Contains(t, out.Warnings[0], "held by only 1 of the 4")
+		require.Contains(t, out.Warnings[1], "decaying")
+		require.Contains(t, out.Warnings[2], "no republish observed")
+	})
+
+	t.Run("record that disappeared is reported", func(t *testing.T) {
+		out := providerRecordFreshness([]ProviderRecordObservation{
+			observation(0, []string{"a"}, []string{"b"}),
+			observation(time.Hour, []string{}, []string{"a", "b"}),
+		})
+
+		require.Nil(t, out.LastPublishedAfter)
+		require.Len(t, out.Warnings, 1)
+		require.Contains(t, out.Warnings[0], "held by none")
+	})
+}
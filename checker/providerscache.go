@@ -0,0 +1,64 @@
+package checker
+
+import (
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// maxCachedProviders is the number of lookups whose providers are cached
+const maxCachedProviders = 10_000
+
+// providersCache caches the providers found for CIDs in the DHT and IPNI, so
+// that checks of the same CID with different options don't look them up
+// again. A nil providersCache caches nothing.
+type providersCache struct {
+	lru *expirable.LRU[string, foundProviders]
+}
+
+// foundProviders are the providers found by a lookup, from each source
+type foundProviders struct {
+	dht  []peer.AddrInfo
+	ipni []ipniProvider
+}
+
+func newProvidersCache(ttl time.Duration) *providersCache {
+	return &providersCache{lru: expirable.NewLRU[string, foundProviders](maxCachedProviders, nil, ttl)}
+}
+
+// providersCacheKey identifies the lookups that find the same providers:
+// both the DHT and IPNI index the multihash of the CID
+func providersCacheKey(c cid.Cid, ipniURL string) string {
+	return c.Hash().B58String() + " " + ipniURL
+}
+
+func (c *providersCache) get(key string) (foundProviders, bool) {
+	if c == nil {
+		return foundProviders{}, false
+	}
+	return c.lru.Get(key)
+}
+
+func (c *providersCache) add(key string, found foundProviders) {
+	if c == nil {
+		return
+	}
+	c.lru.Add(key, found)
+}
+
+// channels returns the providers as if they were being found
+func (f foundProviders) channels() (<-chan peer.AddrInfo, <-chan ipniProvider) {
+	dhtCh := make(chan peer.AddrInfo, len(f.dht))
+	for _, p := range f.dht {
+		dhtCh <- p
+	}
+	close(dhtCh)
+	ipniCh := make(chan ipniProvider, len(f.ipni))
+	for _, p := range f.ipni {
+		ipniCh <- p
+	}
+	close(ipniCh)
+	return dhtCh, ipniCh
+}
@@ -0,0 +1,45 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvidersCache(t *testing.T) {
+	var disabled *providersCache
+	disabled.add("key", foundProviders{})
+	_, ok := disabled.get("key")
+	require.False(t, ok)
+
+	c := newProvidersCache(time.Minute)
+	v1 := cid.MustParse("bafkreiaywbvlbhlh5hwnzmdldkonaxfqvbfndhbqwjq6mxrtdsy4z7jbti")
+	v0 := cid.NewCidV0(v1.Hash())
+	// lookups of the same multihash share the providers, unless the indexer differs
+	require.Equal(t, providersCacheKey(v1, DefaultIndexerURL), providersCacheKey(v0, DefaultIndexerURL))
+	require.NotEqual(t, providersCacheKey(v1, DefaultIndexerURL), providersCacheKey(v1, "https://indexer.example"))
+
+	found := foundProviders{
+		dht:  []peer.AddrInfo{{ID: test.RandPeerIDFatal(t)}},
+		ipni: []ipniProvider{{AddrInfo: peer.AddrInfo{ID: test.RandPeerIDFatal(t)}, Protocols: []string{ProtocolBitswap}}},
+	}
+	c.add(providersCacheKey(v1, DefaultIndexerURL), found)
+	cached, ok := c.get(providersCacheKey(v0, DefaultIndexerURL))
+	require.True(t, ok)
+
+	dhtCh, ipniCh := cached.channels()
+	var dht []peer.AddrInfo
+	for p := range dhtCh {
+		dht = append(dht, p)
+	}
+	var ipni []ipniProvider
+	for p := range ipniCh {
+		ipni = append(ipni, p)
+	}
+	require.Equal(t, found.dht, dht)
+	require.Equal(t, found.ipni, ipni)
+}
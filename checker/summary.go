@@ -0,0 +1,158 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Verdicts of the checks of a CID
+const (
+	// VerdictRetrievable means that a peer served the data
+	VerdictRetrievable = "retrievable"
+	// VerdictAdvertisedButUnreachable means that the CID is advertised, but
+	// no advertising peer served the data
+	VerdictAdvertisedButUnreachable = "advertised_but_unreachable"
+	// VerdictReachableButNotAdvertised means that the peer served the data,
+	// but didn't advertise the CID, so other peers can't find it
+	VerdictReachableButNotAdvertised = "reachable_but_not_advertised"
+	// VerdictNotFound means that the CID isn't advertised, and wasn't served
+	VerdictNotFound = "not_found"
+)
+
+// Steps of the retrieval of a CID, in order, which fail in
+// Summary.FailedSteps
+const (
+	// StepProviderRecord is finding a provider record of the CID in the DHT or IPNI
+	StepProviderRecord = "provider_record"
+	// StepPeerRouting is finding the addresses of the peer in the DHT
+	StepPeerRouting = "peer_routing"
+	// StepConnection is connecting to the peer
+	StepConnection = "connection"
+	// StepDataTransfer is the peer serving the data over one of the probes
+	StepDataTransfer = "data_transfer"
+)
+
+// Summary is the outcome of the check of a CID, so that clients don't have
+// to interpret the results themselves
+type Summary struct {
+	// Verdict is one of the Verdict* constants
+	Verdict string
+	// FailedSteps are the Step* steps that failed, in order
+	FailedSteps []string
+	// Explanations explain the verdict and the failed steps in plain language
+	Explanations []string
+}
+
+func (s *Summary) fail(step, explanation string) {
+	s.FailedSteps = append(s.FailedSteps, step)
+	s.Explanations = append(s.Explanations, explanation)
+}
+
+// SummarizeProviders summarizes the results of CheckCID. The CID is
+// retrievable when any of the providers served it.
+func SummarizeProviders(providers []ProviderOutput) Summary {
+	s := Summary{FailedSteps: []string{}, Explanations: []string{}}
+	if len(providers) == 0 {
+		s.Verdict = VerdictNotFound
+		s.fail(StepProviderRecord, "No provider record of the CID was found in the Amino DHT or IPNI: no peer advertised that it has the data, so clients can't find it.")
+		return s
+	}
+
+	var reachable, served int
+	for _, p := range providers {
+		if p.ConnectionError != "" {
+			continue
+		}
+		reachable++
+		if p.Probes.DataAvailable() {
+			served++
+		}
+	}
+
+	switch {
+	case served > 0:
+		s.Verdict = VerdictRetrievable
+		s.Explanations = append(s.Explanations, fmt.Sprintf("%d of the %d providers found served the data.", served, len(providers)))
+	case reachable == 0:
+		s.Verdict = VerdictAdvertisedButUnreachable
+		s.fail(StepConnection, fmt.Sprintf("None of the %d providers found could be connected to: they may be offline, behind a firewall or NAT, or advertise addresses that aren't reachable.", len(providers)))
+	default:
+		s.Verdict = VerdictAdvertisedButUnreachable
+		s.fail(StepDataTransfer, fmt.Sprintf("%d of the %d providers found could be connected to, but none of them served the data: they may no longer have it, or took too long to respond.", reachable, len(providers)))
+	}
+	return s
+}
+
+// SummarizePeerCheck summarizes the result of CheckPeer for the multiaddr
+// that was checked. The CID is retrievable when the peer served it and
+// advertised it in the DHT or IPNI.
+func SummarizePeerCheck(ma multiaddr.Multiaddr, out *PeerCheckOutput) Summary {
+	s := Summary{FailedSteps: []string{}, Explanations: []string{}}
+	advertised := out.ProviderRecordFromPeerInDHT || out.ProviderRecordFromPeerInIPNI
+	served := out.ConnectionError == "" && out.Probes.DataAvailable()
+
+	switch {
+	case advertised && served:
+		s.Verdict = VerdictRetrievable
+		s.Explanations = append(s.Explanations, "The peer advertised the CID and served the data.")
+	case advertised:
+		s.Verdict = VerdictAdvertisedButUnreachable
+		s.Explanations = append(s.Explanations, "The peer advertised the CID, but didn't serve the data.")
+	case served:
+		s.Verdict = VerdictReachableButNotAdvertised
+		s.Explanations = append(s.Explanations, "The peer served the data, but didn't advertise the CID.")
+	default:
+		s.Verdict = VerdictNotFound
+		s.Explanations = append(s.Explanations, "The peer didn't advertise the CID, and didn't serve the data.")
+	}
+
+	if !advertised {
+		explanation := "The CID isn't advertised by the peer in the Amino DHT or IPNI, so clients that don't already know the peer can't find it."
+		if out.ProviderStatusInIPNI.BehindHead {
+			explanation += " The indexer hasn't ingested the latest advertisements of the peer yet."
+		}
+		s.fail(StepProviderRecord, explanation)
+	}
+
+	if addr, _ := peer.SplitAddr(ma); addr == nil {
+		if len(out.PeerFoundInDHT) == 0 {
+			s.fail(StepPeerRouting, "The addresses of the peer weren't found in the Amino DHT, so clients that only know its peer ID can't connect to it.")
+		}
+	} else if _, ok := out.PeerFoundInDHT[addr.String()]; !ok {
+		s.fail(StepPeerRouting, "The multiaddr isn't among the addresses of the peer in the Amino DHT, so clients that only know its peer ID won't use it.")
+	}
+
+	if out.ConnectionError != "" {
+		s.fail(StepConnection, fmt.Sprintf("The peer couldn't be connected to (%s): machines on the internet can't reach it, which may be fixed by opening the firewall, forwarding ports, or using a relay.", out.ConnectionError))
+	} else if !served {
+		s.fail(StepDataTransfer, probesFailure(out.Probes))
+	}
+	return s
+}
+
+// probesFailure explains why the probes didn't find the data
+func probesFailure(results ProbeResults) string {
+	if res, ok := results[TransportBitswap].(BitswapCheckOutput); ok {
+		switch {
+		case res.Error != "":
+			return fmt.Sprintf("The peer failed to serve the data over Bitswap: %s.", res.Error)
+		case !res.Responded:
+			return "The peer didn't respond in time whether it has the data over Bitswap."
+		default:
+			return "The peer responded that it doesn't have the data over Bitswap."
+		}
+	}
+	if res, ok := results[TransportGraphsync].(GraphsyncCheckOutput); ok {
+		switch {
+		case res.VoucherRequired:
+			return "The peer requires a voucher or payment to serve the data over Graphsync."
+		case res.Error != "":
+			return fmt.Sprintf("The peer failed to serve the data over Graphsync: %s.", res.Error)
+		default:
+			return "The peer didn't serve the data over Graphsync."
+		}
+	}
+	return "The peer didn't serve the data."
+}
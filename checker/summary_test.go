@@ -0,0 +1,95 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeProviders(t *testing.T) {
+	s := SummarizeProviders(nil)
+	require.Equal(t, VerdictNotFound, s.Verdict)
+	require.Equal(t, []string{StepProviderRecord}, s.FailedSteps)
+
+	unreachable := ProviderOutput{ConnectionError: "no addresses"}
+	s = SummarizeProviders([]ProviderOutput{unreachable})
+	require.Equal(t, VerdictAdvertisedButUnreachable, s.Verdict)
+	require.Equal(t, []string{StepConnection}, s.FailedSteps)
+
+	notServing := ProviderOutput{Probes: ProbeResults{TransportBitswap: BitswapCheckOutput{Responded: true}}}
+	s = SummarizeProviders([]ProviderOutput{unreachable, notServing})
+	require.Equal(t, VerdictAdvertisedButUnreachable, s.Verdict)
+	require.Equal(t, []string{StepDataTransfer}, s.FailedSteps)
+
+	serving := ProviderOutput{Probes: ProbeResults{TransportGraphsync: GraphsyncCheckOutput{BlockServed: true}}}
+	s = SummarizeProviders([]ProviderOutput{unreachable, notServing, serving})
+	require.Equal(t, VerdictRetrievable, s.Verdict)
+	require.Empty(t, s.FailedSteps)
+	require.Equal(t, []string{"1 of the 3 providers found served the data."}, s.Explanations)
+}
+
+func TestSummarizePeerCheck(t *testing.T) {
+	const peerID = "12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"
+	peerOnly := multiaddr.StringCast("/p2p/" + peerID)
+	withAddr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + peerID)
+	found := ProbeResults{TransportBitswap: BitswapCheckOutput{Responded: true, Found: true}}
+
+	for _, tc := range []struct {
+		name        string
+		ma          multiaddr.Multiaddr
+		out         PeerCheckOutput
+		verdict     string
+		failedSteps []string
+	}{
+		{
+			name: "advertised and served",
+			ma:   withAddr,
+			out: PeerCheckOutput{
+				ProviderRecordFromPeerInDHT: true,
+				PeerFoundInDHT:              map[string]int{"/ip4/1.2.3.4/tcp/4001": 3},
+				Probes:                      found,
+			},
+			verdict:     VerdictRetrievable,
+			failedSteps: []string{},
+		},
+		{
+			name: "served but not advertised, from an address missing in the DHT",
+			ma:   withAddr,
+			out: PeerCheckOutput{
+				PeerFoundInDHT: map[string]int{"/ip4/5.6.7.8/tcp/4001": 3},
+				Probes:         found,
+			},
+			verdict:     VerdictReachableButNotAdvertised,
+			failedSteps: []string{StepProviderRecord, StepPeerRouting},
+		},
+		{
+			name: "advertised but unreachable",
+			ma:   peerOnly,
+			out: PeerCheckOutput{
+				ProviderRecordFromPeerInIPNI: true,
+				ConnectionError:              "failed to dial",
+			},
+			verdict:     VerdictAdvertisedButUnreachable,
+			failedSteps: []string{StepPeerRouting, StepConnection},
+		},
+		{
+			name: "neither advertised nor served",
+			ma:   peerOnly,
+			out: PeerCheckOutput{
+				PeerFoundInDHT: map[string]int{"/ip4/1.2.3.4/tcp/4001": 3},
+				Probes:         ProbeResults{TransportBitswap: BitswapCheckOutput{Responded: true}},
+			},
+			verdict:     VerdictNotFound,
+			failedSteps: []string{StepProviderRecord, StepDataTransfer},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := SummarizePeerCheck(tc.ma, &tc.out)
+			require.Equal(t, tc.verdict, s.Verdict)
+			require.Equal(t, tc.failedSteps, s.FailedSteps)
+			// the verdict is explained, then each failed step
+			require.Len(t, s.Explanations, len(tc.failedSteps)+1)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package checker
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var errTestHostPoolClosed = errors.New("test host pool closed: shutting down")
+
+// testHostPool hands out hosts to run checks from. size idle hosts are
+// created in advance, and new hosts are created when none is idle. A host is
+// recycled when the check closes it, up to size idle hosts, after its
+// connections, stream handlers, network notifiees and peerstore entries are
+// cleared so that checks remain isolated. Hosts in use are closed on shutdown.
+type testHostPool struct {
+	// size is the number of idle hosts to keep, hosts aren't reused when 0
+	size    int
+	metrics *checkerMetrics
+
+	mu       sync.Mutex
+	idle     []*pooledHost
+	inUse    map[*pooledHost]struct{}
+	creating int
+	closed   bool
+}
+
+// pooledHost is a test host that goes back to the pool when closed
+type pooledHost struct {
+	host.Host
+	pool *testHostPool
+	// protocols handled by the host when created, other handlers are set by checks
+	protocols []protocol.ID
+	// network records the notifiees registered by the check, e.g. by Graphsync
+	network *notifieesNetwork
+	release sync.Once
+}
+
+func newPooledHost(h host.Host, pool *testHostPool, protocols []protocol.ID) *pooledHost {
+	return &pooledHost{
+		Host:      h,
+		pool:      pool,
+		protocols: protocols,
+		network:   &notifieesNetwork{Network: h.Network()},
+	}
+}
+
+func (h *pooledHost) Network() network.Network {
+	return h.network
+}
+
+func (h *pooledHost) Close() error {
+	h.release.Do(func() { h.pool.put(h) })
+	return nil
+}
+
+// notifieesNetwork records the notifiees registered on the network, so that
+// they can be removed when the host is recycled
+type notifieesNetwork struct {
+	network.Network
+
+	mu        sync.Mutex
+	notifiees []network.Notifiee
+}
+
+func (n *notifieesNetwork) Notify(nf network.Notifiee) {
+	n.mu.Lock()
+	n.notifiees = append(n.notifiees, nf)
+	n.mu.Unlock()
+	n.Network.Notify(nf)
+}
+
+func (n *notifieesNetwork) StopNotify(nf network.Notifiee) {
+	n.mu.Lock()
+	n.notifiees = slices.DeleteFunc(n.notifiees, func(registered network.Notifiee) bool { return registered == nf })
+	n.mu.Unlock()
+	n.Network.StopNotify(nf)
+}
+
+// stopNotifyAll removes the notifiees that are still registered
+func (n *notifieesNetwork) stopNotifyAll() {
+	n.mu.Lock()
+	notifiees := n.notifiees
+	n.notifiees = nil
+	n.mu.Unlock()
+	for _, nf := range notifiees {
+		n.Network.StopNotify(nf)
+	}
+}
+
+func (p *testHostPool) get(create func() (host.Host, error)) (host.Host, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errTestHostPoolClosed
+	}
+	var h *pooledHost
+	if n := len(p.idle); n > 0 {
+		h = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if h == nil {
+		var err error
+		h, err = p.create(create)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		p.metrics.testHostReused()
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = h.Host.Close()
+		return nil, errTestHostPoolClosed
+	}
+	if p.inUse == nil {
+		p.inUse = make(map[*pooledHost]struct{})
+	}
+	p.inUse[h] = struct{}{}
+	p.updateMetrics()
+	p.mu.Unlock()
+
+	return h, nil
+}
+
+func (p *testHostPool) create(create func() (host.Host, error)) (*pooledHost, error) {
+	h, err := create()
+	if err != nil {
+		return nil, err
+	}
+	p.metrics.testHostCreated()
+	return newPooledHost(h, p, h.Mux().Protocols()), nil
+}
+
+// fill creates hosts until there are size idle hosts
+func (p *testHostPool) fill(create func() (host.Host, error)) {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle)+p.creating >= p.size {
+			p.mu.Unlock()
+			return
+		}
+		p.creating++
+		p.mu.Unlock()
+
+		h, err := p.create(create)
+
+		p.mu.Lock()
+		p.creating--
+		if err != nil {
+			p.mu.Unlock()
+			return
+		}
+		if p.closed {
+			p.mu.Unlock()
+			_ = h.Host.Close()
+			return
+		}
+		p.idle = append(p.idle, h)
+		p.updateMetrics()
+		p.mu.Unlock()
+	}
+}
+
+// put recycles the host, or closes it when the pool is full
+func (p *testHostPool) put(h *pooledHost) {
+	p.mu.Lock()
+	delete(p.inUse, h)
+	reuse := !p.closed && len(p.idle) < p.size
+	p.updateMetrics()
+	p.mu.Unlock()
+
+	if !reuse || !h.reset() {
+		_ = h.Host.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.size {
+		_ = h.Host.Close()
+		return
+	}
+	// a recycled host gets a new pooledHost, as the check may still hold the previous one
+	p.idle = append(p.idle, newPooledHost(h.Host, p, h.protocols))
+	p.updateMetrics()
+}
+
+// reset closes all the connections of the host, removes the stream handlers
+// and network notifiees set by the check and forgets every other peer. It
+// returns false if the host can't be reused.
+func (h *pooledHost) reset() bool {
+	// Graphsync never removes the notifiee it registers, which would otherwise
+	// keep the instance alive and notified of the connections of later checks
+	h.network.stopNotifyAll()
+	for _, p := range h.Network().Peers() {
+		if err := h.Network().ClosePeer(p); err != nil {
+			return false
+		}
+	}
+	for _, proto := range h.Mux().Protocols() {
+		if !slices.Contains(h.protocols, proto) {
+			h.RemoveStreamHandler(proto)
+		}
+	}
+	ps := h.Peerstore()
+	for _, p := range ps.Peers() {
+		if p == h.ID() {
+			continue
+		}
+		ps.ClearAddrs(p)
+		ps.RemovePeer(p)
+	}
+	return len(h.Network().Peers()) == 0
+}
+
+// closeAll closes the idle hosts and the hosts still in use
+func (p *testHostPool) closeAll() {
+	p.mu.Lock()
+	p.closed = true
+	hosts := p.idle
+	for h := range p.inUse {
+		hosts = append(hosts, h)
+	}
+	p.idle = nil
+	p.inUse = nil
+	p.updateMetrics()
+	p.mu.Unlock()
+
+	for _, h := range hosts {
+		_ = h.Host.Close()
+	}
+}
+
+// updateMetrics must be called with the lock held
+func (p *testHostPool) updateMetrics() {
+	p.metrics.setTestHostPool(len(p.idle), len(p.inUse))
+}
+
+// newTestHost returns a host to run a check from, which must be closed when
+// the check is done
+func (chk *Checker) newTestHost() (host.Host, error) {
+	return chk.testHosts.get(chk.createTestHost)
+}
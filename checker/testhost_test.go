@@ -0,0 +1,104 @@
+package checker
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalTestHost() (host.Host, error) {
+	return libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+}
+
+func TestTestHostPoolRecyclesHosts(t *testing.T) {
+	ctx := context.Background()
+
+	target, err := newLocalTestHost()
+	require.NoError(t, err)
+	defer target.Close()
+
+	chk := &Checker{createTestHost: newLocalTestHost}
+	chk.testHosts.size = 1
+	chk.testHosts.fill(chk.createTestHost)
+	require.Len(t, chk.testHosts.idle, 1)
+	idleID := chk.testHosts.idle[0].ID()
+
+	h, err := chk.newTestHost()
+	require.NoError(t, err)
+	require.Equal(t, idleID, h.ID())
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: target.ID(), Addrs: target.Addrs()}))
+	h.SetStreamHandler("/ipfs-check/test", func(s network.Stream) { _ = s.Reset() })
+	require.NoError(t, h.Close())
+
+	// the host goes back to the pool without any state from the check
+	h, err = chk.newTestHost()
+	require.NoError(t, err)
+	require.Equal(t, idleID, h.ID())
+	require.Empty(t, h.Network().Peers())
+	require.Empty(t, h.Peerstore().Addrs(target.ID()))
+	require.NotContains(t, h.Mux().Protocols(), "/ipfs-check/test")
+	require.NoError(t, h.Close())
+
+	chk.testHosts.closeAll()
+	require.Empty(t, chk.testHosts.idle)
+	_, err = chk.newTestHost()
+	require.ErrorIs(t, err, errTestHostPoolClosed)
+}
+
+func TestTestHostPoolRemovesGraphsyncNotifiee(t *testing.T) {
+	ctx := context.Background()
+
+	target, err := newLocalTestHost()
+	require.NoError(t, err)
+	defer target.Close()
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(&memstore.Store{})
+	gsimpl.New(ctx, gsnet.NewFromLibp2pHost(target), lsys)
+
+	chk := &Checker{createTestHost: newLocalTestHost}
+	chk.testHosts.size = 1
+	defer chk.testHosts.closeAll()
+
+	h, err := chk.newTestHost()
+	require.NoError(t, err)
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: target.ID(), Addrs: target.Addrs()}))
+	checkGraphsyncCID(ctx, h, blocks.NewBlock([]byte("missing")).Cid(), target.ID())
+	network := h.(*pooledHost).network
+	require.NotEmpty(t, network.notifiees)
+	require.NoError(t, h.Close())
+
+	// the notifiee of the Graphsync instance is removed when the host is recycled
+	require.Empty(t, network.notifiees)
+	recycled, err := chk.newTestHost()
+	require.NoError(t, err)
+	require.Equal(t, h.ID(), recycled.ID())
+	require.NoError(t, recycled.Close())
+}
+
+func TestTestHostPoolCloseAll(t *testing.T) {
+	chk := &Checker{createTestHost: newLocalTestHost}
+
+	closed, err := chk.newTestHost()
+	require.NoError(t, err)
+	inUse, err := chk.newTestHost()
+	require.NoError(t, err)
+
+	// without a pool size, hosts are closed rather than recycled
+	require.NoError(t, closed.Close())
+	require.Empty(t, closed.Network().ListenAddresses())
+	require.Len(t, chk.testHosts.inUse, 1)
+
+	chk.testHosts.closeAll()
+	require.Empty(t, chk.testHosts.inUse)
+	require.Empty(t, inUse.Network().ListenAddresses())
+}
@@ -0,0 +1,27 @@
+package checker
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ipfs/ipfs-check/checker")
+
+// newIPNITransport returns a transport that traces each request to the
+// indexers, and records its latency in the metrics
+func newIPNITransport(metrics *checkerMetrics) http.RoundTripper {
+	return otelhttp.NewTransport(ipniMetricsTransport{RoundTripper: http.DefaultTransport, metrics: metrics})
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,263 @@
+// Package client is a client of the ipfs-check HTTP API, /api/v1.
+//
+// The types of the API are shared with the ipfs-check server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetries is the number of times a check is retried by default
+	DefaultRetries = 2
+	// DefaultRetryBackoff is the delay before the first retry, doubled for each retry
+	DefaultRetryBackoff = time.Second
+	// DefaultConcurrency is the number of checks run at the same time by CheckBatch and CheckStream
+	DefaultConcurrency = 4
+
+	// maxRetryAfter caps the delay requested by the server with Retry-After
+	maxRetryAfter = time.Minute
+)
+
+// Client checks the retrievability of data with an ipfs-check server
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	userAgent    string
+	retries      int
+	retryBackoff time.Duration
+	concurrency  int
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client of the requests, http.DefaultClient by default
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.httpClient = c
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests
+func WithUserAgent(ua string) Option {
+	return func(cl *Client) {
+		cl.userAgent = ua
+	}
+}
+
+// WithRetries sets how many times a check is retried when the server is
+// starting, fails or can't be reached, waiting backoff before the first
+// retry and twice as long before each next one. Invalid checks aren't retried.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(cl *Client) {
+		cl.retries = retries
+		cl.retryBackoff = backoff
+	}
+}
+
+// WithConcurrency sets how many checks CheckBatch and CheckStream run at the same time
+func WithConcurrency(n int) Option {
+	return func(cl *Client) {
+		cl.concurrency = n
+	}
+}
+
+// New returns a client of the ipfs-check server at baseURL, e.g.
+// https://check.ipfs.network
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: expected an http or https URL", baseURL)
+	}
+	c := &Client{
+		baseURL:      u,
+		httpClient:   http.DefaultClient,
+		retries:      DefaultRetries,
+		retryBackoff: DefaultRetryBackoff,
+		concurrency:  DefaultConcurrency,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.concurrency < 1 {
+		c.concurrency = 1
+	}
+	return c, nil
+}
+
+// ResponseError is returned when the server responds with an error
+type ResponseError struct {
+	StatusCode int
+	Errors     []APIError
+	// retryAfter is the delay requested by the server before retrying
+	retryAfter time.Duration
+}
+
+func (e *ResponseError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Code+": "+err.Message)
+	}
+	if len(msgs) == 0 {
+		return fmt.Sprintf("ipfs-check: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("ipfs-check: %s: %s", http.StatusText(e.StatusCode), strings.Join(msgs, ", "))
+}
+
+// HasCode returns whether the server returned an error with the code
+func (e *ResponseError) HasCode(code string) bool {
+	for _, err := range e.Errors {
+		if err.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCID finds the providers of the CID, and checks whether they serve it
+func (c *Client) CheckCID(ctx context.Context, cid string) (*CheckResponse, error) {
+	return c.Check(ctx, CheckInput{CID: cid})
+}
+
+// CheckPeer checks the connectivity of the peer, given its multiaddr or
+// /p2p/<peer id>
+func (c *Client) CheckPeer(ctx context.Context, multiaddr string) (*CheckResponse, error) {
+	return c.Check(ctx, CheckInput{Multiaddr: multiaddr})
+}
+
+// CheckCIDFromPeer checks whether the peer serves the CID, and whether it is
+// advertised in the DHT and IPNI
+func (c *Client) CheckCIDFromPeer(ctx context.Context, cid, multiaddr string) (*CheckResponse, error) {
+	return c.Check(ctx, CheckInput{CID: cid, Multiaddr: multiaddr})
+}
+
+// Check runs the check, retrying it when the server is starting, fails or
+// can't be reached
+func (c *Client) Check(ctx context.Context, in CheckInput) (*CheckResponse, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := c.check(ctx, in)
+		if err == nil || attempt >= c.retries || !retryable(err) || ctx.Err() != nil {
+			return out, err
+		}
+
+		wait := backoff
+		var respErr *ResponseError
+		if errors.As(err, &respErr) && respErr.retryAfter > 0 {
+			wait = respErr.retryAfter
+		}
+		backoff *= 2
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) check(ctx context.Context, in CheckInput) (*CheckResponse, error) {
+	u := c.baseURL.JoinPath("api/v1/check")
+	u.RawQuery = in.Values().Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out CheckResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusOK {
+		respErr := &ResponseError{StatusCode: resp.StatusCode, Errors: out.Errors}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			respErr.retryAfter = min(time.Duration(secs)*time.Second, maxRetryAfter)
+		}
+		return nil, respErr
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("decoding the response of ipfs-check: %w", decodeErr)
+	}
+	return &out, nil
+}
+
+// retryable returns whether the check may succeed if retried
+func retryable(err error) bool {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= 500 || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	// the server couldn't be reached
+	return true
+}
+
+// CheckResult is the result of one of the checks of CheckStream
+type CheckResult struct {
+	// Index is the position of the check in the inputs
+	Index    int
+	Input    CheckInput
+	Response *CheckResponse
+	Err      error
+}
+
+// CheckBatch runs the checks, up to the concurrency of the client at the same
+// time, and returns their results in the order of the inputs. The server
+// doesn't have a batch endpoint, so each check is a separate request.
+func (c *Client) CheckBatch(ctx context.Context, inputs []CheckInput) []CheckResult {
+	results := make([]CheckResult, len(inputs))
+	for res := range c.CheckStream(ctx, inputs) {
+		results[res.Index] = res
+	}
+	return results
+}
+
+// CheckStream runs the checks, up to the concurrency of the client at the
+// same time, and sends each result as soon as its check is done. The channel
+// is closed when all the checks are done. Checks not started when ctx is done
+// fail with the error of ctx.
+func (c *Client) CheckStream(ctx context.Context, inputs []CheckInput) <-chan CheckResult {
+	ch := make(chan CheckResult, len(inputs))
+	sem := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+	for i, in := range inputs {
+		wg.Add(1)
+		go func(i int, in CheckInput) {
+			defer wg.Done()
+			res := CheckResult{Index: i, Input: in}
+			select {
+			case sem <- struct{}{}:
+				res.Response, res.Err = c.Check(ctx, in)
+				<-sem
+			case <-ctx.Done():
+				res.Err = ctx.Err()
+			}
+			ch <- res
+		}(i, in)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
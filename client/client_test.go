@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRetriesWhenNotReady(t *testing.T) {
+	// requests are checked by the test, as the handler runs in another goroutine
+	var mu sync.Mutex
+	var requests []*url.URL
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL)
+		n := len(requests)
+		mu.Unlock()
+
+		out := CheckResponse{Mode: ModeCID, Input: CheckInput{CID: "bafkqaaa"}, Results: []PeerResult{}, Errors: []APIError{}}
+		if n == 1 {
+			out.Errors = []APIError{{Code: ErrorCodeNotReady, Message: "starting"}}
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithRetries(1, time.Millisecond))
+	require.NoError(t, err)
+	out, err := c.Check(context.Background(), CheckInput{CID: "bafkqaaa", GetBlock: true})
+	require.NoError(t, err)
+	require.Equal(t, ModeCID, out.Mode)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 2)
+	for _, u := range requests {
+		require.Equal(t, "/api/v1/check", u.Path)
+		require.Equal(t, "bafkqaaa", u.Query().Get("cid"))
+		require.Equal(t, "true", u.Query().Get("getBlock"))
+		require.False(t, u.Query().Has("fresh"))
+	}
+}
+
+func TestCheckDoesNotRetryInvalidChecks(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(CheckResponse{Errors: []APIError{{Code: ErrorCodeInvalidCID, Message: "invalid cid"}}})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithRetries(3, time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.CheckCID(context.Background(), "notacid")
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusBadRequest, respErr.StatusCode)
+	require.True(t, respErr.HasCode(ErrorCodeInvalidCID))
+	require.EqualValues(t, 1, requests.Load())
+}
+
+func TestCheckBatch(t *testing.T) {
+	// the slow check only completes once the other checks are streamed
+	slowDone := make(chan struct{})
+	var releaseSlow sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := CheckInput{CID: r.URL.Query().Get("cid"), Multiaddr: r.URL.Query().Get("multiaddr")}
+		if in.CID == "slow" {
+			<-slowDone
+		}
+		_ = json.NewEncoder(w).Encode(CheckResponse{Input: in, Results: []PeerResult{}, Errors: []APIError{}})
+	}))
+	defer srv.Close()
+	defer releaseSlow.Do(func() { close(slowDone) })
+
+	c, err := New(srv.URL, WithConcurrency(2))
+	require.NoError(t, err)
+	inputs := []CheckInput{{CID: "slow"}, {CID: "fast"}, {Multiaddr: "/p2p/12D3KooW"}}
+
+	var order []string
+	for res := range c.CheckStream(context.Background(), inputs) {
+		require.NoError(t, res.Err)
+		require.Equal(t, inputs[res.Index], res.Response.Input)
+		order = append(order, res.Input.CID)
+		if len(order) == 2 {
+			releaseSlow.Do(func() { close(slowDone) })
+		}
+	}
+	require.Len(t, order, 3)
+	require.Equal(t, "slow", order[2])
+
+	results := c.CheckBatch(context.Background(), inputs)
+	for i, res := range results {
+		require.NoError(t, res.Err)
+		require.Equal(t, inputs[i], res.Response.Input)
+	}
+}
+
+func TestCheckInputValues(t *testing.T) {
+	in := CheckInput{CID: "bafkqaaa", TimeoutSeconds: 1.5, Fresh: true, Probes: []string{"bitswap", "graphsync"}}
+	require.Equal(t, "cid=bafkqaaa&fresh=true&probes=bitswap%2Cgraphsync&timeoutSeconds=1.5", in.Values().Encode())
+}
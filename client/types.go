@@ -0,0 +1,307 @@
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check modes, depending on the input
+const (
+	ModeCID          = "cid"
+	ModeMultiaddr    = "multiaddr"
+	ModeCIDMultiaddr = "cid_multiaddr"
+)
+
+// Error codes of APIError
+const (
+	ErrorCodeMissingInput     = "missing_input"
+	ErrorCodeInvalidCID       = "invalid_cid"
+	ErrorCodeInvalidMultiaddr = "invalid_multiaddr"
+	ErrorCodeInvalidTimeout   = "invalid_timeout"
+	ErrorCodeInvalidParameter = "invalid_parameter"
+	// ErrorCodeNotReady is returned with a 503 while the server is starting
+	ErrorCodeNotReady    = "not_ready"
+	ErrorCodeCheckFailed = "check_failed"
+)
+
+// CheckResponse is the response of /api/v1/check. It has the same shape
+// whatever is checked, and errors are reported in it too.
+type CheckResponse struct {
+	// Mode is ModeCID, ModeMultiaddr or ModeCIDMultiaddr, depending on the input
+	Mode  string     `json:"mode,omitempty"`
+	Input CheckInput `json:"input"`
+	// Results has a result for each provider found for the cid, or for the given peer
+	Results []PeerResult `json:"results"`
+	Summary CheckSummary `json:"summary"`
+	Errors  []APIError   `json:"errors"`
+	// CheckedAt is when the check ran, which is before the request when served from the cache
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+// CheckInput is what to check, and how. At least one of CID and Multiaddr is
+// required. The server returns it with the defaults filled in.
+type CheckInput struct {
+	CID                   string  `json:"cid,omitempty"`
+	Multiaddr             string  `json:"multiaddr,omitempty"`
+	TimeoutSeconds        float64 `json:"timeoutSeconds"`
+	IPNIIndexer           string  `json:"ipniIndexer"`
+	ProbeBitswapProtocols bool    `json:"probeBitswapProtocols"`
+	GetBlock              bool    `json:"getBlock"`
+	// Probes are the names of the probes run against each peer, e.g. bitswap
+	// or graphsync. By default, the first probe that applies runs.
+	Probes []string `json:"probes"`
+	Fresh  bool     `json:"fresh"`
+}
+
+// Values returns the query parameters of the check. Zero values are left out,
+// so that the server defaults apply.
+func (in CheckInput) Values() url.Values {
+	v := url.Values{}
+	if in.CID != "" {
+		v.Set("cid", in.CID)
+	}
+	if in.Multiaddr != "" {
+		v.Set("multiaddr", in.Multiaddr)
+	}
+	if in.TimeoutSeconds > 0 {
+		v.Set("timeoutSeconds", strconv.FormatFloat(in.TimeoutSeconds, 'f', -1, 64))
+	}
+	if in.IPNIIndexer != "" {
+		v.Set("ipniIndexer", in.IPNIIndexer)
+	}
+	if len(in.Probes) > 0 {
+		v.Set("probes", strings.Join(in.Probes, ","))
+	}
+	for name, set := range map[string]bool{
+		"probeBitswapProtocols": in.ProbeBitswapProtocols,
+		"getBlock":              in.GetBlock,
+		"fresh":                 in.Fresh,
+	} {
+		if set {
+			v.Set(name, "true")
+		}
+	}
+	return v
+}
+
+// Verdicts of CheckSummary, when a cid is checked
+const (
+	// VerdictRetrievable means that a peer served the data
+	VerdictRetrievable = "retrievable"
+	// VerdictAdvertisedButUnreachable means that the cid is advertised, but no advertising peer served the data
+	VerdictAdvertisedButUnreachable = "advertised_but_unreachable"
+	// VerdictReachableButNotAdvertised means that the given peer served the data, but didn't advertise the cid
+	VerdictReachableButNotAdvertised = "reachable_but_not_advertised"
+	// VerdictNotFound means that the cid isn't advertised, and wasn't served
+	VerdictNotFound = "not_found"
+)
+
+// Steps of the retrieval of a cid, in CheckSummary.FailedSteps
+const (
+	StepProviderRecord = "provider_record"
+	StepPeerRouting    = "peer_routing"
+	StepConnection     = "connection"
+	StepDataTransfer   = "data_transfer"
+)
+
+// CheckSummary counts the checked peers, and tells whether the cid is
+// retrievable and why, so that clients don't have to interpret the results
+type CheckSummary struct {
+	Peers int `json:"peers"`
+	// Reachable is the number of peers that could be connected to
+	Reachable int `json:"reachable"`
+	// DataAvailable is the number of peers that served the data, always 0 in multiaddr mode
+	DataAvailable int `json:"dataAvailable"`
+	// Verdict is one of the Verdict* constants, empty in multiaddr mode
+	Verdict string `json:"verdict,omitempty"`
+	// FailedSteps are the Step* steps of the retrieval that failed, in order
+	FailedSteps []string `json:"failedSteps"`
+	// Explanations explain the verdict and the failed steps in plain language
+	Explanations []string `json:"explanations"`
+}
+
+// APIError is an error of the API, with one of the ErrorCode* codes
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PeerResult is the result of the check of a single peer
+type PeerResult struct {
+	PeerID string `json:"peerId"`
+	// Source is where the provider was found, IPNI or Amino DHT, in cid mode
+	Source string `json:"source,omitempty"`
+	// Addrs are the public addresses of the provider, in cid mode
+	Addrs            []string `json:"addrs,omitempty"`
+	ConnectionError  string   `json:"connectionError,omitempty"`
+	ConnectionMaddrs []string `json:"connectionMaddrs"`
+	// Transport is the transport used to check the data availability: bitswap or graphsync
+	Transport string `json:"transport,omitempty"`
+	// Probes maps the name of each probe that ran against the peer to its result
+	Probes map[string]ProbeResult `json:"probes,omitempty"`
+	// AdvertisedProtocols are the transport protocols of the provider record, when found in IPNI
+	AdvertisedProtocols []string `json:"advertisedProtocols,omitempty"`
+	// Metadata maps each protocol of the provider record to its advertised metadata
+	Metadata           map[string]ProtocolMetadata `json:"metadata,omitempty"`
+	ProtocolMismatches []string                    `json:"protocolMismatches,omitempty"`
+	Bitswap            *BitswapResult              `json:"bitswap,omitempty"`
+	Graphsync          *GraphsyncResult            `json:"graphsync,omitempty"`
+	// DHT and IPNI are the routing of the peer, when a peer is given
+	DHT  *PeerDHTResult  `json:"dht,omitempty"`
+	IPNI *PeerIPNIResult `json:"ipni,omitempty"`
+	// Health is the connectivity of the peer, in multiaddr mode
+	Health *PeerHealthResult `json:"health,omitempty"`
+}
+
+// ProbeResult is the result of a probe. Bitswap or Graphsync is set for the
+// probes of these transports, and Result for other probes.
+type ProbeResult struct {
+	DataAvailable bool             `json:"dataAvailable"`
+	Bitswap       *BitswapResult   `json:"bitswap,omitempty"`
+	Graphsync     *GraphsyncResult `json:"graphsync,omitempty"`
+	// Result is the result of other probes, as encoded by the probe
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// ProtocolMetadata is the metadata advertised to the indexer for a transport
+// protocol. PieceCID, VerifiedDeal and FastRetrieval are advertised for
+// transport-graphsync-filecoinv1.
+type ProtocolMetadata struct {
+	PieceCID      string `json:"pieceCid,omitempty"`
+	VerifiedDeal  bool   `json:"verifiedDeal,omitempty"`
+	FastRetrieval bool   `json:"fastRetrieval,omitempty"`
+}
+
+// BitswapResult is the result of a Bitswap check. Found means that the peer
+// claimed to have the block, unless GetBlock was set.
+type BitswapResult struct {
+	Found                   bool            `json:"found"`
+	Responded               bool            `json:"responded"`
+	Error                   string          `json:"error,omitempty"`
+	Protocol                string          `json:"protocol,omitempty"`
+	LegacyOnly              bool            `json:"legacyOnly"`
+	SupportedProtocols      map[string]bool `json:"supportedProtocols,omitempty"`
+	DurationMs              float64         `json:"durationMs"`
+	ConnectDurationMs       float64         `json:"connectDurationMs"`
+	FirstResponseDurationMs float64         `json:"firstResponseDurationMs"`
+	BlockDurationMs         float64         `json:"blockDurationMs"`
+	BlockSize               int             `json:"blockSize"`
+	BlockReceived           bool            `json:"blockReceived"`
+	BlockValid              bool            `json:"blockValid"`
+}
+
+// GraphsyncResult is the result of a Graphsync check
+type GraphsyncResult struct {
+	DurationMs      float64 `json:"durationMs"`
+	Responded       bool    `json:"responded"`
+	BlockServed     bool    `json:"blockServed"`
+	Status          string  `json:"status,omitempty"`
+	VoucherRequired bool    `json:"voucherRequired"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// PeerDHTResult is what the DHT knows about the peer
+type PeerDHTResult struct {
+	// PeerAddrs maps the addresses of the peer found in the DHT to the number of peers returning them
+	PeerAddrs map[string]int `json:"peerAddrs"`
+	// ProviderRecord is whether the DHT has a provider record of the cid for the peer, when a cid is given
+	ProviderRecord *bool                            `json:"providerRecord,omitempty"`
+	Replication    *ProviderRecordReplicationResult `json:"replication,omitempty"`
+	Freshness      *ProviderRecordFreshnessResult   `json:"freshness,omitempty"`
+}
+
+// PeerIPNIResult is what the indexer knows about the peer
+type PeerIPNIResult struct {
+	// ProviderRecord is whether the indexer has a provider record of the cid for the peer
+	ProviderRecord bool               `json:"providerRecord"`
+	ProviderStatus IPNIProviderStatus `json:"providerStatus"`
+}
+
+// IPNIProviderStatus is the state of the peer in the indexer
+type IPNIProviderStatus struct {
+	Found                    bool       `json:"found"`
+	LastAdvertisement        string     `json:"lastAdvertisement,omitempty"`
+	LastAdvertisementTime    *time.Time `json:"lastAdvertisementTime,omitempty"`
+	SinceLastAdvertisementMs float64    `json:"sinceLastAdvertisementMs"`
+	Lag                      int        `json:"lag"`
+	PublisherID              string     `json:"publisherId,omitempty"`
+	PublisherAddrs           []string   `json:"publisherAddrs,omitempty"`
+	Inactive                 bool       `json:"inactive"`
+	LastIngestionError       string     `json:"lastIngestionError,omitempty"`
+	LastIngestionErrorTime   string     `json:"lastIngestionErrorTime,omitempty"`
+	HeadAdvertisement        string     `json:"headAdvertisement,omitempty"`
+	HeadError                string     `json:"headError,omitempty"`
+	BehindHead               bool       `json:"behindHead"`
+	Error                    string     `json:"error,omitempty"`
+}
+
+// PeerHealthResult is the connectivity of the peer
+type PeerHealthResult struct {
+	PingRTTMs    float64          `json:"pingRttMs"`
+	PingError    string           `json:"pingError,omitempty"`
+	AgentVersion string           `json:"agentVersion,omitempty"`
+	Protocols    []string         `json:"protocols"`
+	ListenAddrs  []string         `json:"listenAddrs"`
+	AddrDials    []AddrDialResult `json:"addrDials"`
+	DHTServer    *DHTServerResult `json:"dhtServer,omitempty"`
+}
+
+// AddrDialResult is the result of dialing a single address of the peer
+type AddrDialResult struct {
+	Addr       string  `json:"addr"`
+	Transport  string  `json:"transport"`
+	DurationMs float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// DHTServerResult is the result of the DHT server check of the peer
+type DHTServerResult struct {
+	IsServer          bool           `json:"isServer"`
+	Useful            bool           `json:"useful"`
+	FindNode          DHTQueryResult `json:"findNode"`
+	GetProviders      DHTQueryResult `json:"getProviders"`
+	GetValue          DHTQueryResult `json:"getValue"`
+	Neighbors         int            `json:"neighbors"`
+	NeighborsWithPeer int            `json:"neighborsWithPeer"`
+	Warnings          []string       `json:"warnings"`
+}
+
+// DHTQueryResult is the result of a single DHT request
+type DHTQueryResult struct {
+	DurationMs           float64 `json:"durationMs"`
+	CloserPeers          int     `json:"closerPeers"`
+	CloserPeersWithAddrs int     `json:"closerPeersWithAddrs"`
+	Error                string  `json:"error,omitempty"`
+}
+
+// ProviderRecordReplicationResult is the replication of the provider record
+// among the closest DHT peers to the cid
+type ProviderRecordReplicationResult struct {
+	ClosestPeers       int               `json:"closestPeers"`
+	PeersWithRecord    []string          `json:"peersWithRecord"`
+	PeersWithoutRecord []string          `json:"peersWithoutRecord"`
+	PeersFailed        map[string]string `json:"peersFailed"`
+	Addrs              map[string]int    `json:"addrs"`
+	Error              string            `json:"error,omitempty"`
+}
+
+// ProviderRecordFreshnessResult is the history of the replication of the
+// provider record
+type ProviderRecordFreshnessResult struct {
+	Observations        []ProviderRecordObservationResult `json:"observations"`
+	LastPublishedAfter  *time.Time                        `json:"lastPublishedAfter,omitempty"`
+	LastRepublishBefore *time.Time                        `json:"lastRepublishBefore,omitempty"`
+	ExpiresNotBefore    *time.Time                        `json:"expiresNotBefore,omitempty"`
+	Warnings            []string                          `json:"warnings"`
+}
+
+// ProviderRecordObservationResult is a past observation of the replication of
+// the provider record
+type ProviderRecordObservationResult struct {
+	Time            time.Time `json:"time"`
+	ClosestPeers    int       `json:"closestPeers"`
+	PeersWithRecord int       `json:"peersWithRecord"`
+}
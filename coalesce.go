@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// checkExecutions tracks the executions of checks, which go on when the
+// requests waiting for them go away, so that shutdown can wait for them and
+// cancel them. The zero value is ready to use.
+type checkExecutions struct {
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+	// running counts the requests waiting for a check, and the checks whose requests went away
+	running sync.WaitGroup
+}
+
+// context returns the context the checks run with, cancelled by cancelAll
+func (e *checkExecutions) context() context.Context {
+	e.once.Do(func() {
+		e.ctx, e.cancel = context.WithCancel(context.Background())
+	})
+	return e.ctx
+}
+
+// cancelAll cancels the running checks
+func (e *checkExecutions) cancelAll() {
+	e.context()
+	e.cancel()
+}
+
+// wait waits for the running checks to finish, or for ctx to be done
+func (e *checkExecutions) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// coalescedCheck is the result of a check shared by identical requests, with
+// the ID of the request that ran it, which its log lines carry
+type coalescedCheck struct {
+	cachedCheck
+	requestID string
+}
+
+// runCoalesced runs the check, unless an identical check is already running,
+// in which case it waits for the result of that check instead. It returns
+// early if ctx is done, without stopping the check that other requests may
+// be waiting for: the check only stops when it completes or on shutdown.
+func (d *daemon) runCoalesced(ctx context.Context, key string, check func(context.Context) (cachedCheck, error)) (cachedCheck, error) {
+	var ran bool
+	// Counted until the result is delivered, so that shutdown waits for the
+	// check even when this request goes away
+	d.checks.running.Add(1)
+	ch := d.runningChecks.DoChan(key, func() (interface{}, error) {
+		ran = true
+		// The check keeps the values of the request, e.g. its logger and span
+		checkCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		defer context.AfterFunc(d.checks.context(), cancel)()
+		res, err := check(checkCtx)
+		return coalescedCheck{cachedCheck: res, requestID: requestID(ctx)}, err
+	})
+
+	select {
+	case res := <-ch:
+		d.checks.running.Done()
+		shared := res.Val.(coalescedCheck)
+		if !ran {
+			// The logs of the check carry the ID of the request that ran it
+			logger(ctx).Info("Shared the result of an identical check already running", "checkRequestID", shared.requestID)
+			d.metrics.checkCoalesced()
+		}
+		if res.Err != nil {
+			return cachedCheck{}, res.Err
+		}
+		return shared.cachedCheck, nil
+	case <-ctx.Done():
+		go func() {
+			<-ch
+			d.checks.running.Done()
+		}()
+		return cachedCheck{}, ctx.Err()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCoalesced(t *testing.T) {
+	d := &daemon{}
+
+	var runs atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	check := func(context.Context) (cachedCheck, error) {
+		if runs.Add(1) == 1 {
+			started <- struct{}{}
+		}
+		<-release
+		return cachedCheck{Body: []byte(`{}`)}, nil
+	}
+
+	var logs syncBuffer
+	l := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	var wg sync.WaitGroup
+	results := make([]cachedCheck, 3)
+	errs := make([]error, 3)
+	ids := make([]string, 3)
+	for i := range results {
+		r := httptest.NewRequest("GET", "/check", nil)
+		w := httptest.NewRecorder()
+		ctx := withRequestID(w, r.WithContext(withLogger(r.Context(), l)))
+		ids[i] = w.Header().Get(requestIDHeader)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = d.runCoalesced(ctx, "key", check)
+		}(i)
+		// the first request runs the check
+		if i == 0 {
+			<-started
+		}
+	}
+
+	// a request that goes away doesn't wait for the check, nor stops it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := d.runCoalesced(ctx, "key", check)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	wg.Wait()
+	require.EqualValues(t, 1, runs.Load())
+	for i, res := range results {
+		require.NoError(t, errs[i])
+		require.JSONEq(t, `{}`, string(res.Body))
+	}
+
+	// the requests sharing the check log the ID of the request that ran it
+	var shared []map[string]string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]string
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		shared = append(shared, entry)
+	}
+	require.Len(t, shared, 2)
+	require.ElementsMatch(t, ids[1:], []string{shared[0]["requestID"], shared[1]["requestID"]})
+	for _, entry := range shared {
+		require.Equal(t, ids[0], entry["checkRequestID"])
+	}
+
+	// checks that are not running anymore run again
+	_, err = d.runCoalesced(context.Background(), "key", check)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, runs.Load())
+}
+
+func TestRunCoalescedShutdown(t *testing.T) {
+	d := &daemon{}
+
+	check := func(ctx context.Context) (cachedCheck, error) {
+		<-ctx.Done()
+		return cachedCheck{}, ctx.Err()
+	}
+
+	// the check goes on when its request goes away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := d.runCoalesced(ctx, "key", check)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// so shutdown waits for it
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	require.ErrorIs(t, d.checks.wait(waitCtx), context.DeadlineExceeded)
+
+	// until the checks are cancelled
+	d.checks.cancelAll()
+	require.NoError(t, d.checks.wait(context.Background()))
+}
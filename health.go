@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+)
+
+// ipniHealthInterval is how long the reachability of the IPNI indexer is
+// cached, so that frequent readiness probes don't hit the indexer each time.
+const ipniHealthInterval = 30 * time.Second
+
+// readinessConfig configures the /readyz endpoint
+type readinessConfig struct {
+	// minimum number of peers in the routing table of the DHT client
+	minRoutingTableSize int
+	// indexer that must be reachable, not checked when empty
+	ipniURL string
+}
+
+// readinessOutput is the body of the /readyz endpoint
+type readinessOutput struct {
+	Ready bool
+	// DHTReady is false while the accelerated DHT client maps the network
+	DHTReady         bool
+	RoutingTableSize int
+	IPNIReachable    bool
+	IPNIError        string
+}
+
+// dhtReady returns whether the DHT client can be used for checks. The
+// accelerated DHT client isn't until its first crawl of the network finished.
+func (d *daemon) dhtReady() bool {
+	if frt, ok := d.dht.(*fullrt.FullRT); ok {
+		return frt.Ready()
+	}
+	return true
+}
+
+// routingTableSize returns the number of peers known by the DHT client
+func (d *daemon) routingTableSize() int {
+	switch rt := d.dht.(type) {
+	case *fullrt.FullRT:
+		return len(rt.Stat())
+	case *dht.IpfsDHT:
+		return rt.RoutingTable().Size()
+	}
+	return 0
+}
+
+// mustStart blocks until the DHT client is ready, or the context is done
+func (d *daemon) mustStart(ctx context.Context) {
+	if d.dhtReady() {
+		return
+	}
+	slog.Info("Please wait, initializing accelerated-dht client.. (mapping Amino DHT takes 5 mins or more)")
+	for !d.dhtReady() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	slog.Info("Accelerated DHT client is ready")
+}
+
+// dhtNotReadyMessage explains why requests are rejected until the DHT client is ready
+const dhtNotReadyMessage = "the accelerated DHT client is still mapping the Amino DHT, which takes 5 mins or more"
+
+// requireDHTReady responds with a 503 until the DHT client is ready, whose
+// body is written by notReady
+func requireDHTReady(d *daemon, handler http.Handler, notReady func(w http.ResponseWriter, r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.dhtReady() {
+			w.Header().Add("Access-Control-Allow-Origin", "*")
+			w.Header().Add("Retry-After", "60")
+			notReady(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// textNotReady responds to requests rejected by requireDHTReady in plain text
+func textNotReady(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "ipfs-check is starting: "+dhtNotReadyMessage+". Please retry later.", http.StatusServiceUnavailable)
+}
+
+// ipniHealth caches the reachability of an IPNI indexer
+type ipniHealth struct {
+	url string
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (h *ipniHealth) check(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.checked) < ipniHealthInterval {
+		return h.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	h.err = func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(h.url, "/")+"/health", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s responded with status %d", h.url, resp.StatusCode)
+		}
+		return nil
+	}()
+	h.checked = time.Now()
+	return h.err
+}
+
+// healthzHandler responds as long as the process is able to serve requests
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyzHandler responds with a 200 when the DHT client is ready and has
+// enough peers, and the indexer is reachable, and a 503 otherwise.
+func readyzHandler(d *daemon, cfg readinessConfig) http.HandlerFunc {
+	var ipni *ipniHealth
+	if cfg.ipniURL != "" {
+		ipni = &ipniHealth{url: cfg.ipniURL}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := readinessOutput{
+			DHTReady:         d.dhtReady(),
+			RoutingTableSize: d.routingTableSize(),
+			IPNIReachable:    true,
+		}
+		if ipni != nil {
+			if err := ipni.check(r.Context()); err != nil {
+				out.IPNIReachable = false
+				out.IPNIError = err.Error()
+			}
+		}
+		out.Ready = out.DHTReady && out.RoutingTableSize >= cfg.minRoutingTableSize && out.IPNIReachable
+
+		w.Header().Add("Content-Type", "application/json")
+		if !out.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
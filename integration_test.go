@@ -1,18 +1,33 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	bsnet "github.com/ipfs/boxo/bitswap/network"
 	bsserver "github.com/ipfs/boxo/bitswap/server"
 	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/ipfs-check/checker"
+	checkclient "github.com/ipfs/ipfs-check/client"
 	"github.com/ipfs/ipfs-check/test"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	mplex "github.com/libp2p/go-libp2p-mplex"
@@ -25,12 +40,22 @@ import (
 	"github.com/multiformats/go-multihash"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestBasicIntegration(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Record the spans of the checks, and continue the traces of incoming requests
+	spans := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
 	testDHTPrefix := protocol.TestingID
 	testDHTID := protocol.TestingID + "/kad/1.0.0"
 
@@ -57,23 +82,28 @@ func TestBasicIntegration(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		pm, err := dhtProtocolMessenger(testDHTID, queryHost)
-		require.NoError(t, err)
 		queryDHT, err := dht.New(ctx, queryHost, dht.ProtocolPrefix(testDHTPrefix), dht.BootstrapPeers(peer.AddrInfo{ID: dhtHost.ID(), Addrs: dhtHost.Addrs()}))
 		require.NoError(t, err)
 
+		chk, err := checker.New(
+			checker.WithHost(queryHost),
+			checker.WithDHT(queryDHT),
+			checker.WithDHTProtocol(testDHTID),
+			checker.WithTestHostFactory(func() (host.Host, error) {
+				return libp2p.New(libp2p.DefaultMuxers,
+					libp2p.Muxer(mplex.ID, mplex.DefaultTransport),
+					libp2p.EnableHolePunching())
+			}),
+		)
+		require.NoError(t, err)
+
 		d := &daemon{
 			promRegistry: prometheus.NewRegistry(),
 			h:            queryHost,
 			dht:          queryDHT,
-			dhtMessenger: pm,
-			createTestHost: func() (host.Host, error) {
-				return libp2p.New(libp2p.DefaultMuxers,
-					libp2p.Muxer(mplex.ID, mplex.DefaultTransport),
-					libp2p.EnableHolePunching())
-			},
+			checker:      chk,
 		}
-		_ = startServer(ctx, d, ":1234", "", "")
+		_ = startServer(ctx, d, ":1234", "", "", routingV1Config{enabled: true}, readinessConfig{}, time.Second)
 	}()
 
 	h, err := libp2p.New()
@@ -116,11 +146,25 @@ func TestBasicIntegration(t *testing.T) {
 		obj := test.Query(t, "http://localhost:1234", testCid.String(), hostAddr.String())
 
 		obj.Value("ProviderRecordFromPeerInDHT").Boolean().IsTrue()
+		obj.Value("ProviderRecordReplicationInDHT").Object().Value("PeersWithRecord").Array().NotEmpty()
 		obj.Value("ConnectionError").String().IsEmpty()
 		obj.Value("ConnectionMaddrs").Array().ContainsAll(h.Addrs()[0])
 		obj.Value("DataAvailableOverBitswap").Object().Value("Error").String().IsEmpty()
 		obj.Value("DataAvailableOverBitswap").Object().Value("Found").Boolean().IsTrue()
 		obj.Value("DataAvailableOverBitswap").Object().Value("Responded").Boolean().IsTrue()
+		obj.Value("DataAvailableOverBitswap").Object().Value("Protocol").String().IsEqual("/ipfs/bitswap/1.2.0")
+		obj.Value("DataAvailableOverBitswap").Object().Value("LegacyOnly").Boolean().IsFalse()
+		obj.Value("DataAvailableOverBitswap").Object().Value("ConnectDuration").Number().Gt(0)
+		obj.Value("DataAvailableOverBitswap").Object().Value("FirstResponseDuration").Number().Gt(0)
+		// small blocks are sent directly in response to a WANT-HAVE
+		obj.Value("DataAvailableOverBitswap").Object().Value("BlockSize").Number().IsEqual(len(testData))
+		obj.Value("Probes").Object().Keys().ContainsOnly("bitswap")
+		obj.Value("Probes").Object().Value("bitswap").Object().Value("Found").Boolean().IsTrue()
+
+		// the peer doesn't speak Graphsync
+		obj = test.QueryWithParams(t, "http://localhost:1234", testCid.String(), hostAddr.String(), map[string]string{"probes": "graphsync"})
+		obj.Value("ConnectionError").String().Contains("protocols not supported by the peer: graphsync")
+		obj.Value("Probes").Object().IsEmpty()
 	})
 
 	t.Run("Data on reachable peer that's not advertised", func(t *testing.T) {
@@ -136,6 +180,10 @@ func TestBasicIntegration(t *testing.T) {
 		obj := test.Query(t, "http://localhost:1234", testCid.String(), hostAddr.String())
 
 		obj.Value("ProviderRecordFromPeerInDHT").Boolean().IsFalse()
+		obj.Value("ProviderRecordReplicationInDHT").Object().Value("PeersWithRecord").Array().IsEmpty()
+		// the test peer never announced to the indexer, nor publishes advertisements
+		obj.Value("ProviderStatusInIPNI").Object().Value("Found").Boolean().IsFalse()
+		obj.Value("ProviderStatusInIPNI").Object().Value("BehindHead").Boolean().IsFalse()
 		obj.Value("ConnectionError").String().IsEmpty()
 		obj.Value("ConnectionMaddrs").Array().ContainsAll(h.Addrs()[0])
 		obj.Value("DataAvailableOverBitswap").Object().Value("Error").String().IsEmpty()
@@ -154,6 +202,7 @@ func TestBasicIntegration(t *testing.T) {
 		obj := test.Query(t, "http://localhost:1234", testCid.String(), hostAddr.String())
 
 		obj.Value("ProviderRecordFromPeerInDHT").Boolean().IsTrue()
+		obj.Value("ProviderRecordReplicationInDHT").Object().Value("PeersWithRecord").Array().NotEmpty()
 		obj.Value("ConnectionError").String().IsEmpty()
 		obj.Value("ConnectionMaddrs").Array().ContainsAll(h.Addrs()[0])
 		obj.Value("DataAvailableOverBitswap").Object().Value("Error").String().IsEmpty()
@@ -161,6 +210,57 @@ func TestBasicIntegration(t *testing.T) {
 		obj.Value("DataAvailableOverBitswap").Object().Value("Responded").Boolean().IsTrue()
 	})
 
+	t.Run("Data fetched and verified from reachable peer", func(t *testing.T) {
+		testData := []byte(t.Name())
+		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		testCid := cid.NewCidV1(cid.Raw, mh)
+		testBlock, err := blocks.NewBlockWithCid(testData, testCid)
+		require.NoError(t, err)
+		err = bstore.Put(ctx, testBlock)
+		require.NoError(t, err)
+
+		obj := test.QueryWithParams(t, "http://localhost:1234", testCid.String(), hostAddr.String(), map[string]string{"getBlock": "true"})
+
+		obj.Value("ConnectionError").String().IsEmpty()
+		obj.Value("DataAvailableOverBitswap").Object().Value("Error").String().IsEmpty()
+		obj.Value("DataAvailableOverBitswap").Object().Value("Found").Boolean().IsTrue()
+		obj.Value("DataAvailableOverBitswap").Object().Value("BlockReceived").Boolean().IsTrue()
+		obj.Value("DataAvailableOverBitswap").Object().Value("BlockValid").Boolean().IsTrue()
+		obj.Value("DataAvailableOverBitswap").Object().Value("BlockSize").Number().IsEqual(len(testData))
+	})
+
+	t.Run("Reachable peer without cid", func(t *testing.T) {
+		obj := test.Query(t, "http://localhost:1234", "", hostAddr.String())
+
+		obj.Value("ConnectionError").String().IsEmpty()
+		obj.Value("ConnectionMaddrs").Array().ContainsAll(h.Addrs()[0])
+		obj.Value("PingError").String().IsEmpty()
+		obj.Value("PingRTT").Number().Gt(0)
+		obj.Value("AgentVersion").String().NotEmpty()
+		obj.Value("Protocols").Array().ContainsAll("/ipfs/bitswap/1.2.0")
+		// the test peer only listens on private addresses, which aren't dialed separately
+		obj.Value("AddrDials").Array().IsEmpty()
+		obj.NotContainsKey("DataAvailableOverBitswap")
+		obj.Value("DHTServer").Object().Value("IsServer").Boolean().IsFalse()
+		obj.Value("DHTServer").Object().Value("Useful").Boolean().IsFalse()
+	})
+
+	t.Run("DHT server peer without cid", func(t *testing.T) {
+		mas, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: dhtHost.ID(), Addrs: dhtHost.Addrs()})
+		require.NoError(t, err)
+
+		obj := test.Query(t, "http://localhost:1234", "", mas[0].String())
+
+		obj.Value("ConnectionError").String().IsEmpty()
+		// the test DHT has no other server, so the responses have no closer peers
+		dhtServer := obj.Value("DHTServer").Object()
+		dhtServer.Value("IsServer").Boolean().IsTrue()
+		dhtServer.Value("FindNode").Object().Value("Error").String().IsEmpty()
+		dhtServer.Value("GetProviders").Object().Value("Error").String().IsEmpty()
+		dhtServer.Value("GetValue").Object().Value("Error").String().IsEmpty()
+	})
+
 	t.Run("Data found on reachable peer with just cid", func(t *testing.T) {
 		testData := []byte(t.Name())
 		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
@@ -177,6 +277,7 @@ func TestBasicIntegration(t *testing.T) {
 
 		res.Length().IsEqual(1)
 		res.Value(0).Object().Value("ID").String().IsEqual(h.ID().String())
+		res.Value(0).Object().Value("CachedAt").String().AsDateTime(time.RFC3339Nano).Gt(time.Now().Add(-time.Minute))
 		res.Value(0).Object().Value("ConnectionError").String().IsEmpty()
 		testHostAddrs := h.Addrs()
 		for _, addr := range testHostAddrs {
@@ -189,5 +290,223 @@ func TestBasicIntegration(t *testing.T) {
 		res.Value(0).Object().Value("DataAvailableOverBitswap").Object().Value("Error").String().IsEmpty()
 		res.Value(0).Object().Value("DataAvailableOverBitswap").Object().Value("Found").Boolean().IsTrue()
 		res.Value(0).Object().Value("DataAvailableOverBitswap").Object().Value("Responded").Boolean().IsTrue()
+		res.Value(0).Object().Value("Transport").String().IsEqual("bitswap")
 	})
+
+	t.Run("Providers found with the delegated routing v1 server", func(t *testing.T) {
+		testData := []byte(t.Name())
+		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		testCid := cid.NewCidV1(cid.Raw, mh)
+		err = dhtClient.Provide(ctx, testCid, true)
+		require.NoError(t, err)
+
+		crClient, err := client.New("http://localhost:1234")
+		require.NoError(t, err)
+		provsIter, err := crClient.FindProviders(ctx, testCid)
+		require.NoError(t, err)
+		provs, err := iter.ReadAllResults(provsIter)
+		require.NoError(t, err)
+
+		require.Len(t, provs, 1)
+		prov, ok := provs[0].(*types.PeerRecord)
+		require.True(t, ok)
+		require.Equal(t, h.ID(), *prov.ID)
+
+		peers, err := crClient.FindPeers(ctx, h.ID())
+		require.NoError(t, err)
+		peerRecords, err := iter.ReadAllResults(peers)
+		require.NoError(t, err)
+		require.Len(t, peerRecords, 1)
+		require.NotEmpty(t, peerRecords[0].Addrs)
+	})
+
+	t.Run("Data found on Graphsync only peer with just cid", func(t *testing.T) {
+		gsHost, err := libp2p.New()
+		require.NoError(t, err)
+		defer gsHost.Close()
+
+		store := &memstore.Store{}
+		lsys := cidlink.DefaultLinkSystem()
+		lsys.SetReadStorage(store)
+		lsys.SetWriteStorage(store)
+		_ = gsimpl.New(ctx, gsnet.NewFromLibp2pHost(gsHost), lsys)
+
+		gsDHTClient, err := dht.New(ctx, gsHost, dht.ProtocolPrefix(testDHTPrefix), dht.Mode(dht.ModeClient), dht.BootstrapPeers(peer.AddrInfo{ID: dhtHost.ID(), Addrs: dhtHost.Addrs()}))
+		require.NoError(t, err)
+		defer gsDHTClient.Close()
+		err = gsDHTClient.Bootstrap(ctx)
+		require.NoError(t, err)
+		for gsDHTClient.RoutingTable().Size() == 0 {
+			select {
+			case <-ctx.Done():
+				t.Fatal(ctx.Err())
+			case <-time.After(time.Millisecond * 5):
+			}
+		}
+
+		testData := []byte(t.Name())
+		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		testCid := cid.NewCidV1(cid.Raw, mh)
+		err = store.Put(ctx, testCid.KeyString(), testData)
+		require.NoError(t, err)
+		err = gsDHTClient.Provide(ctx, testCid, true)
+		require.NoError(t, err)
+
+		res := test.QueryCid(t, "http://localhost:1234", testCid.String())
+
+		res.Length().IsEqual(1)
+		res.Value(0).Object().Value("ID").String().IsEqual(gsHost.ID().String())
+		res.Value(0).Object().Value("ConnectionError").String().IsEmpty()
+		res.Value(0).Object().Value("Transport").String().IsEqual("graphsync")
+		res.Value(0).Object().Value("DataAvailableOverGraphsync").Object().Value("Error").String().IsEmpty()
+		res.Value(0).Object().Value("DataAvailableOverGraphsync").Object().Value("Responded").Boolean().IsTrue()
+		res.Value(0).Object().Value("DataAvailableOverGraphsync").Object().Value("BlockServed").Boolean().IsTrue()
+		res.Value(0).Object().Value("DataAvailableOverGraphsync").Object().Value("VoucherRequired").Boolean().IsFalse()
+		res.Value(0).Object().Value("Probes").Object().Keys().ContainsOnly("graphsync")
+	})
+	t.Run("Health endpoints", func(t *testing.T) {
+		res, err := http.Get("http://localhost:1234/healthz")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// the test daemon uses the standard DHT client and doesn't check any indexer
+		res, err = http.Get("http://localhost:1234/readyz")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var out readinessOutput
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+		require.True(t, out.Ready)
+		require.True(t, out.DHTReady)
+		require.True(t, out.IPNIReachable)
+	})
+	t.Run("Check spans continue the incoming trace", func(t *testing.T) {
+		testData := []byte(t.Name())
+		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		testCid := cid.NewCidV1(cid.Raw, mh)
+
+		traceID := trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:1234/check?cid="+testCid.String()+"&multiaddr="+hostAddr.String(), nil)
+		require.NoError(t, err)
+		req.Header.Set("traceparent", "00-"+traceID.String()+"-00f067aa0ba902b7-01")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		names := map[string]bool{}
+		for _, span := range spans.GetSpans() {
+			if span.SpanContext.TraceID() == traceID {
+				names[span.Name] = true
+			}
+		}
+		for _, name := range []string{"runPeerCheck", "peerAddrsInDHT", "execOnMany", "dialBitswap", "NewStream", "checkBitswapCID", "ipniProviderCheck"} {
+			require.True(t, names[name], "missing span %s", name)
+		}
+	})
+	t.Run("Versioned API", func(t *testing.T) {
+		testData := []byte(t.Name())
+		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		testCid := cid.NewCidV1(cid.Raw, mh)
+		testBlock, err := blocks.NewBlockWithCid(testData, testCid)
+		require.NoError(t, err)
+		require.NoError(t, bstore.Put(ctx, testBlock))
+		require.NoError(t, dhtClient.Provide(ctx, testCid, true))
+
+		c, err := checkclient.New("http://localhost:1234")
+		require.NoError(t, err)
+		out, err := c.CheckCIDFromPeer(ctx, testCid.String(), hostAddr.String())
+		require.NoError(t, err)
+		require.Equal(t, checkclient.ModeCIDMultiaddr, out.Mode)
+		require.Equal(t, testCid.String(), out.Input.CID)
+		require.Empty(t, out.Errors)
+		require.Equal(t, 1, out.Summary.Reachable)
+		require.Equal(t, 1, out.Summary.DataAvailable)
+		require.Equal(t, checkclient.VerdictRetrievable, out.Summary.Verdict)
+		require.Equal(t, []string{}, out.Summary.FailedSteps)
+		require.Len(t, out.Results, 1)
+		require.Equal(t, h.ID().String(), out.Results[0].PeerID)
+		require.True(t, *out.Results[0].DHT.ProviderRecord)
+		require.True(t, out.Results[0].Bitswap.Found)
+		require.Greater(t, out.Results[0].Bitswap.ConnectDurationMs, 0.0)
+
+		// errors have a code, in the same envelope
+		res, err := http.Get("http://localhost:1234/api/v1/check?cid=" + testCid.String() + "&getBlock=maybe")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+		var errOut checkclient.CheckResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&errOut))
+		require.Equal(t, []checkclient.APIError{{Code: checkclient.ErrorCodeInvalidParameter, Message: "Invalid getBlock value (expected a boolean)"}}, errOut.Errors)
+
+		_, err = c.CheckCID(ctx, "notacid")
+		var respErr *checkclient.ResponseError
+		require.ErrorAs(t, err, &respErr)
+		require.True(t, respErr.HasCode(checkclient.ErrorCodeInvalidCID))
+
+		res, err = http.Get("http://localhost:1234/api/v1/openapi.json")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var spec map[string]any
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&spec))
+		require.Contains(t, spec["paths"], "/api/v1/check")
+	})
+	t.Run("Check logs carry the request ID", func(t *testing.T) {
+		var logs syncBuffer
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		defer slog.SetDefault(defaultLogger)
+
+		testData := []byte(t.Name())
+		mh, err := multihash.Sum(testData, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		testCid := cid.NewCidV1(cid.Raw, mh)
+
+		res, err := http.Get("http://localhost:1234/check?cid=" + testCid.String() + "&multiaddr=" + hostAddr.String())
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		requestID := res.Header.Get("X-Request-ID")
+		require.NotEmpty(t, requestID)
+
+		messages := map[string]bool{}
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry struct {
+				Msg       string
+				RequestID string `json:"requestID"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			if entry.RequestID == requestID {
+				messages[entry.Msg] = true
+			}
+		}
+		for _, msg := range []string{"Checking", "Start of Bitswap check", "End of Bitswap check", "Peer check done"} {
+			require.True(t, messages[msg], "missing log line %q", msg)
+		}
+	})
+}
+
+// syncBuffer is a buffer that logs can be written to from the checks while
+// the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/ipfs/ipfs-check/checker"
+)
+
+// requestIDHeader is the response header carrying the ID of a check request,
+// which is attached to every log line of the check
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestID returns the ID of the check request of the context, empty
+// outside of check requests
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// setupLogging sets the default logger, writing JSON or text lines to stderr
+// from the given level: debug, info, warn or error
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format %q: expected json or text", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// newRequestID returns a random ID for a check request
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// withLogger returns a context whose checks log with l
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return checker.WithLogger(ctx, l)
+}
+
+// logger returns the logger of the context, or the default logger
+func logger(ctx context.Context) *slog.Logger {
+	return checker.Logger(ctx)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupLogging(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	require.NoError(t, setupLogging("debug", "json"))
+	require.True(t, slog.Default().Enabled(context.Background(), slog.LevelDebug))
+	require.NoError(t, setupLogging("WARN", "text"))
+	require.False(t, slog.Default().Enabled(context.Background(), slog.LevelInfo))
+
+	require.Error(t, setupLogging("verbose", "json"))
+	require.Error(t, setupLogging("info", "logfmt"))
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	require.Equal(t, slog.Default(), logger(context.Background()))
+
+	l := slog.Default().With("requestID", newRequestID())
+	require.Equal(t, l, logger(withLogger(context.Background(), l)))
+	require.Len(t, newRequestID(), 16)
+}
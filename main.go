@@ -4,17 +4,18 @@ import (
 	"context"
 	"crypto/subtle"
 	"embed"
-	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-check/checker"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
-	"github.com/multiformats/go-multihash"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -53,107 +54,181 @@ func main() {
 			EnvVars: []string{"IPFS_CHECK_METRICS_AUTH_PASS"},
 			Usage:   "http basic auth password for the metrics endpoints",
 		},
+		&cli.BoolFlag{
+			Name:    "routing-v1-server",
+			Value:   false,
+			EnvVars: []string{"IPFS_CHECK_ROUTING_V1_SERVER"},
+			Usage:   "serve the delegated routing v1 API at /routing/v1, backed by the DHT client",
+		},
+		&cli.BoolFlag{
+			Name:    "routing-v1-filter-protocols",
+			Value:   false,
+			EnvVars: []string{"IPFS_CHECK_ROUTING_V1_FILTER_PROTOCOLS"},
+			Usage:   "filter the delegated routing v1 results with the protocol filter of the checks, unless the request passes filter-protocols",
+		},
+		&cli.BoolFlag{
+			Name:    "routing-v1-ipns-put",
+			Value:   false,
+			EnvVars: []string{"IPFS_CHECK_ROUTING_V1_IPNS_PUT"},
+			Usage:   "publish the IPNS records of delegated routing v1 PUT requests to the DHT, which anyone can send",
+		},
+		&cli.DurationFlag{
+			Name:    "cache-ttl",
+			Value:   0,
+			EnvVars: []string{"IPFS_CHECK_CACHE_TTL"},
+			Usage:   "how long check results are cached and served to identical checks, and the providers found for CIDs reused by other checks, 0 to disable the cache",
+		},
+		&cli.StringFlag{
+			Name:    "cache-dir",
+			Value:   "",
+			EnvVars: []string{"IPFS_CHECK_CACHE_DIR"},
+			Usage:   "directory to persist cached check results in, kept in memory only when empty",
+		},
+		&cli.IntFlag{
+			Name:    "test-host-pool-size",
+			Value:   10,
+			EnvVars: []string{"IPFS_CHECK_TEST_HOST_POOL_SIZE"},
+			Usage:   "number of idle test hosts to keep ready for checks, 0 to create a new host for every check",
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Value:   2 * time.Minute,
+			EnvVars: []string{"IPFS_CHECK_SHUTDOWN_TIMEOUT"},
+			Usage:   "how long to wait for in-flight checks to finish on shutdown",
+		},
+		&cli.IntFlag{
+			Name:    "ready-min-routing-table-size",
+			Value:   10,
+			EnvVars: []string{"IPFS_CHECK_READY_MIN_ROUTING_TABLE_SIZE"},
+			Usage:   "minimum number of peers in the routing table of the DHT client for /readyz to report ready",
+		},
+		&cli.StringFlag{
+			Name:    "ready-ipni-indexer",
+			Value:   defaultIndexerURL,
+			EnvVars: []string{"IPFS_CHECK_READY_IPNI_INDEXER"},
+			Usage:   "IPNI indexer that must be reachable for /readyz to report ready, not checked when empty",
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Value:   "info",
+			EnvVars: []string{"IPFS_CHECK_LOG_LEVEL"},
+			Usage:   "minimum level of the logs: debug, info, warn or error",
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Value:   "json",
+			EnvVars: []string{"IPFS_CHECK_LOG_FORMAT"},
+			Usage:   "format of the logs: json or text",
+		},
 	}
 	app.Action = func(cctx *cli.Context) error {
-		ctx := cctx.Context
+		if err := setupLogging(cctx.String("log-level"), cctx.String("log-format")); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cctx.Context, syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		shutdownTracing, err := setupTracing(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// flush the spans of the last checks, the signal context is already done
+			if err := shutdownTracing(context.Background()); err != nil {
+				slog.Error("Error shutting down tracing", "err", err)
+			}
+		}()
 
-		d, err := newDaemon(ctx, cctx.Bool("accelerated-dht"))
+		d, err := newDaemon(ctx, cctx.Bool("accelerated-dht"), cctx.Int("test-host-pool-size"), cctx.Duration("cache-ttl"))
+		if err != nil {
+			return err
+		}
+		d.checkCache, err = newCheckCache(cctx.Duration("cache-ttl"), cctx.String("cache-dir"))
 		if err != nil {
 			return err
 		}
+		defer func() {
+			if err := d.Close(); err != nil {
+				slog.Error("Error closing the daemon", "err", err)
+			}
+		}()
+
+		routingV1 := routingV1Config{
+			enabled:         cctx.Bool("routing-v1-server"),
+			filterProtocols: cctx.Bool("routing-v1-filter-protocols"),
+			ipnsPut:         cctx.Bool("routing-v1-ipns-put"),
+		}
 
-		return startServer(ctx, d, cctx.String("address"), cctx.String("metrics-auth-username"), cctx.String("metrics-auth-password"))
+		readiness := readinessConfig{
+			minRoutingTableSize: cctx.Int("ready-min-routing-table-size"),
+			ipniURL:             cctx.String("ready-ipni-indexer"),
+		}
+
+		return startServer(ctx, d, cctx.String("address"), cctx.String("metrics-auth-username"), cctx.String("metrics-auth-password"), routingV1, readiness, cctx.Duration("shutdown-timeout"))
 	}
 
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 }
 
 const (
 	defaultCheckTimeout = 60 * time.Second
-	defaultIndexerURL   = "https://cid.contact"
+	defaultIndexerURL   = checker.DefaultIndexerURL
 )
 
-func startServer(ctx context.Context, d *daemon, tcpListener, metricsUsername, metricPassword string) error {
-	log.Printf("Starting %s %s\n", name, version)
+func startServer(ctx context.Context, d *daemon, tcpListener, metricsUsername, metricPassword string, routingV1 routingV1Config, readiness readinessConfig, shutdownTimeout time.Duration) error {
+	slog.Info("Starting "+name, "version", version)
 	l, err := net.Listen("tcp", tcpListener)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Libp2p host peer id %s\n", d.h.ID())
-	log.Printf("Libp2p host listening on %v\n", d.h.Addrs())
+	slog.Info("Libp2p host started", "peerID", d.h.ID(), "addrs", d.h.Addrs())
+	slog.Info("Backend listening", "addr", l.Addr())
 
-	d.mustStart()
+	webAddr := getWebAddress(l)
+	slog.Info("Test frontend", "url", fmt.Sprintf("http://%s/web/?backendURL=http://%s", webAddr, webAddr))
+	slog.Info("Metrics endpoint", "url", fmt.Sprintf("http://%s/metrics", webAddr))
+	slog.Info("Check API", "url", fmt.Sprintf("http://%s/api/v1/check", webAddr), "openapi", fmt.Sprintf("http://%s/api/v1/openapi.json", webAddr))
+	slog.Info("Health endpoints", "urls", []string{fmt.Sprintf("http://%s/healthz", webAddr), fmt.Sprintf("http://%s/readyz", webAddr)})
+	if routingV1.enabled {
+		slog.Info("Delegated routing v1 endpoint", "url", fmt.Sprintf("http://%s/routing/v1", webAddr))
+	}
 
-	log.Printf("Backend ready and listening on %v\n", l.Addr())
+	mux := http.NewServeMux()
 
-	webAddr := getWebAddress(l)
-	log.Printf("Test fronted at http://%s/web/?backendURL=http://%s\n", webAddr, webAddr)
-	log.Printf("Metrics endpoint at http://%s/metrics\n", webAddr)
-	log.Printf("Ready to start serving.")
+	// Serve right away, checks respond with a 503 until the DHT client is ready
+	go func() {
+		d.mustStart(ctx)
+		if ctx.Err() == nil {
+			slog.Info("Ready to start serving")
+		}
+	}()
 
 	checkHandler := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Access-Control-Allow-Origin", "*")
+		w.Header().Add("Access-Control-Expose-Headers", "Age, Cache-Status, "+requestIDHeader)
 
-		maStr := r.URL.Query().Get("multiaddr")
-		cidStr := r.URL.Query().Get("cid")
-		timeoutStr := r.URL.Query().Get("timeoutSeconds")
-		ipniURL := r.URL.Query().Get("ipniIndexer")
+		// The request ID is attached to every log line of the check
+		ctx := withRequestID(w, r)
 
-		if cidStr == "" {
-			http.Error(w, "missing 'cid' query parameter", http.StatusBadRequest)
-			return
-		}
-		cidKey, err := cid.Decode(cidStr)
+		req, err := parseCheckRequest(r.URL.Query())
 		if err != nil {
-			mh, mhErr := multihash.FromB58String(cidStr)
-			if mhErr != nil {
-				mh, mhErr = multihash.FromHexString(cidStr)
-				if mhErr != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
-				}
-			}
-			cidKey = cid.NewCidV1(cid.Raw, mh)
-		}
-
-		checkTimeout := defaultCheckTimeout
-		if timeoutStr != "" {
-			checkTimeout, err = time.ParseDuration(timeoutStr + "s")
-			if err != nil {
-				http.Error(w, "Invalid timeout value (in seconds)", http.StatusBadRequest)
-				return
-			}
-		}
-
-		if ipniURL == "" {
-			ipniURL = defaultIndexerURL
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		log.Printf("Checking %s with timeout %s seconds", cidStr, checkTimeout.String())
-		withTimeout, cancel := context.WithTimeout(r.Context(), checkTimeout)
-		defer cancel()
-
-		var data interface{}
-		if maStr == "" {
-			data, err = d.runCidCheck(withTimeout, cidKey, ipniURL)
-		} else {
-			ma, ai, err400 := parseMultiaddr(maStr)
-			if err400 != nil {
-				http.Error(w, err400.Error(), http.StatusBadRequest)
-				return
-			}
-			data, err = d.runPeerCheck(withTimeout, ma, ai, cidKey, ipniURL)
-		}
+		res, err := d.runCheck(ctx, w.Header(), req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		w.Header().Add("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(data)
+		_, _ = w.Write(res.Body)
 	}
 
 	// Register the default Go collector
@@ -189,49 +264,91 @@ func startServer(ctx context.Context, d *daemon, tcpListener, metricsUsername, m
 	d.promRegistry.MustRegister(requestDuration)
 	d.promRegistry.MustRegister(requestsInFlight)
 
-	// Instrument the checkHandler
-	instrumentedHandler := promhttp.InstrumentHandlerCounter(
-		requestsTotal,
-		promhttp.InstrumentHandlerDuration(
-			requestDuration,
-			promhttp.InstrumentHandlerInFlight(
-				requestsInFlight,
-				http.HandlerFunc(checkHandler),
+	// Instrument the check handlers
+	instrument := func(handler http.Handler) http.Handler {
+		return promhttp.InstrumentHandlerCounter(
+			requestsTotal,
+			promhttp.InstrumentHandlerDuration(
+				requestDuration,
+				promhttp.InstrumentHandlerInFlight(
+					requestsInFlight,
+					handler,
+				),
 			),
-		),
-	)
+		)
+	}
 
-	http.Handle("/check", instrumentedHandler)
+	// Requests rejected while the DHT client isn't ready are instrumented too
+	mux.Handle("/check", instrument(requireDHTReady(d, http.HandlerFunc(checkHandler), textNotReady)))
+	mux.Handle("GET /api/v1/check", instrument(requireDHTReady(d, apiV1CheckHandler(d), apiV1NotReady)))
+	mux.HandleFunc("GET /api/v1/openapi.json", openAPIHandler)
+
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.Handle("/readyz", readyzHandler(d, readiness))
 
 	// Use a single metrics endpoint for all Prometheus metrics
-	http.Handle("/metrics", BasicAuth(promhttp.HandlerFor(d.promRegistry, promhttp.HandlerOpts{}), metricsUsername, metricPassword))
+	mux.Handle("/metrics", BasicAuth(promhttp.HandlerFor(d.promRegistry, promhttp.HandlerOpts{}), metricsUsername, metricPassword))
+
+	if routingV1.enabled {
+		mux.Handle("/routing/v1/", requireDHTReady(d, routingV1Handler(d, routingV1), textNotReady))
+	}
+
+	mux.Handle("GET /network/stats", networkStatsHandler(d))
+	mux.Handle("GET /network/peers/{peerID}", networkPeerHandler(d))
 
 	// Serve frontend on /web
 	fileServer := http.FileServer(http.FS(webFS))
-	http.Handle("/web/", fileServer)
+	mux.Handle("/web/", fileServer)
 	// Set up the root route to redirect to /web
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/web", http.StatusFound)
 	})
 
+	// Checks run with a context that is only cancelled when in-flight checks
+	// didn't finish in time on shutdown
+	defer d.checks.cancelAll()
+	srv := &http.Server{
+		Handler:     tracingHandler(mux),
+		BaseContext: func(net.Listener) context.Context { return d.checks.context() },
+	}
+
 	done := make(chan error, 1)
 	go func() {
 		defer close(done)
-		done <- http.Serve(l, nil)
+		done <- srv.Serve(l)
 	}()
 
 	select {
 	case err := <-done:
 		return err
 	case <-ctx.Done():
-		_ = l.Close()
-		return <-done
 	}
+
+	// Stop accepting requests, and let the in-flight checks finish
+	slog.Info("Shutting down, waiting for in-flight checks to finish", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if err == nil {
+		// Checks shared by several requests go on when their requests go away
+		err = d.checks.wait(shutdownCtx)
+	}
+	if err != nil {
+		slog.Warn("In-flight checks did not finish in time", "err", err)
+		d.checks.cancelAll()
+		_ = srv.Close()
+		// the checks stop once cancelled, before the daemon is closed
+		_ = d.checks.wait(context.Background())
+	}
+	if err := <-done; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 func BasicAuth(handler http.Handler, username, password string) http.Handler {
 	if username == "" || password == "" {
-		log.Println("Warning: no http basic auth for the metrics endpoint.")
+		slog.Warn("No http basic auth for the metrics endpoint")
 		return handler
 	}
 
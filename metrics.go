@@ -0,0 +1,191 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ipfs/ipfs-check/checker"
+	checkclient "github.com/ipfs/ipfs-check/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Check modes, depending on the query parameters
+const (
+	cidCheckMode          = checkclient.ModeCID
+	multiaddrCheckMode    = checkclient.ModeMultiaddr
+	cidMultiaddrCheckMode = checkclient.ModeCIDMultiaddr
+)
+
+// Check outcomes
+const (
+	outcomeFound       = "found"
+	outcomeNotFound    = "not_found"
+	outcomeNoProviders = "no_providers"
+	outcomeReachable   = "reachable"
+	outcomeUnreachable = "unreachable"
+	outcomeError       = "error"
+)
+
+// checkMetrics are the Prometheus metrics recorded by the server for each
+// check, the checker recording its own
+type checkMetrics struct {
+	checksCoalesced  prometheus.Counter
+	checks           *prometheus.CounterVec
+	providersFound   *prometheus.CounterVec
+	connectionErrors *prometheus.CounterVec
+	holePunches      *prometheus.CounterVec
+}
+
+func newCheckMetrics(reg prometheus.Registerer) *checkMetrics {
+	m := &checkMetrics{
+		checksCoalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "check_coalesced_requests_total",
+			Help: "Total number of check requests that shared the execution of an identical check already running",
+		}),
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "checks_total",
+			Help: "Total number of checks by mode (cid, multiaddr or cid_multiaddr) and outcome",
+		}, []string{"mode", "outcome"}),
+		providersFound: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "check_providers_found_total",
+			Help: "Total number of providers found by checks with only a cid, by source",
+		}, []string{"source"}),
+		connectionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "check_connection_errors_total",
+			Help: "Total number of peers checks failed to connect to, by reason",
+		}, []string{"reason"}),
+		holePunches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "check_hole_punches_total",
+			Help: "Total number of peers connected through a relay, by whether a direct connection was established (success or failure)",
+		}, []string{"result"}),
+	}
+
+	reg.MustRegister(
+		m.checksCoalesced,
+		m.checks,
+		m.providersFound,
+		m.connectionErrors,
+		m.holePunches,
+	)
+
+	return m
+}
+
+// observeCidCheck records the outcome of a check with only a cid, and the
+// connections to its providers
+func (m *checkMetrics) observeCidCheck(providers []checker.ProviderOutput) {
+	if m == nil {
+		return
+	}
+	outcome := outcomeNoProviders
+	if len(providers) > 0 {
+		outcome = outcomeUnreachable
+	}
+	for _, p := range providers {
+		m.providersFound.WithLabelValues(p.Source).Inc()
+		m.observeConnection(p.ConnectionError, p.ConnectionMaddrs)
+		if p.ConnectionError != "" {
+			continue
+		}
+		if p.DataAvailableOverBitswap.Found || (p.DataAvailableOverGraphsync != nil && p.DataAvailableOverGraphsync.BlockServed) {
+			outcome = outcomeFound
+		} else if outcome != outcomeFound {
+			outcome = outcomeNotFound
+		}
+	}
+	m.checks.WithLabelValues(cidCheckMode, outcome).Inc()
+}
+
+// observePeerCheck records the outcome of a check with a cid and a multiaddr
+func (m *checkMetrics) observePeerCheck(out *checker.PeerCheckOutput) {
+	if m == nil {
+		return
+	}
+	m.observeConnection(out.ConnectionError, out.ConnectionMaddrs)
+	outcome := outcomeNotFound
+	if out.ConnectionError != "" {
+		outcome = outcomeUnreachable
+	} else if out.DataAvailableOverBitswap.Found {
+		outcome = outcomeFound
+	}
+	m.checks.WithLabelValues(cidMultiaddrCheckMode, outcome).Inc()
+}
+
+// observePeerHealthCheck records the outcome of a check with only a multiaddr
+func (m *checkMetrics) observePeerHealthCheck(out *checker.PeerHealthOutput) {
+	if m == nil {
+		return
+	}
+	m.observeConnection(out.ConnectionError, out.ConnectionMaddrs)
+	outcome := outcomeReachable
+	if out.ConnectionError != "" {
+		outcome = outcomeUnreachable
+	}
+	m.checks.WithLabelValues(multiaddrCheckMode, outcome).Inc()
+}
+
+// observeCheckError records a check that failed to run
+func (m *checkMetrics) observeCheckError(mode string) {
+	if m == nil {
+		return
+	}
+	m.checks.WithLabelValues(mode, outcomeError).Inc()
+}
+
+// observeConnection records why the connection to a peer failed, or whether
+// hole punching succeeded when connected through a relay
+func (m *checkMetrics) observeConnection(connErr string, maddrs []string) {
+	if connErr != "" {
+		m.connectionErrors.WithLabelValues(classifyConnectionError(connErr)).Inc()
+		return
+	}
+	var relayed, direct bool
+	for _, a := range maddrs {
+		if strings.Contains(a, "/p2p-circuit") {
+			relayed = true
+		} else {
+			direct = true
+		}
+	}
+	if relayed {
+		result := "failure"
+		if direct {
+			result = "success"
+		}
+		m.holePunches.WithLabelValues(result).Inc()
+	}
+}
+
+// classifyConnectionError returns the reason of a connection error, from the
+// error messages of go-libp2p. When dialing several addresses fails for
+// different reasons, the first one matching is returned.
+func classifyConnectionError(connErr string) string {
+	for _, c := range []struct {
+		reason     string
+		substrings []string
+	}{
+		{"no_addresses", []string{"no addresses", "failed to find any peer in table", "routing: not found"}},
+		{"no_good_addresses", []string{"no good addresses"}},
+		{"protocol_not_supported", []string{"protocols not supported", "protocol not supported", "failed to negotiate protocol"}},
+		{"peer_id_mismatch", []string{"peer id mismatch"}},
+		{"relay", []string{"NO_RESERVATION", "error opening relay circuit", "relay"}},
+		{"dial_backoff", []string{"dial backoff"}},
+		{"connection_refused", []string{"connection refused"}},
+		{"unreachable", []string{"network is unreachable", "no route to host"}},
+		{"resource_limit", []string{"resource limit exceeded"}},
+		{"timeout", []string{"deadline exceeded", "timeout", "timed out"}},
+	} {
+		for _, sub := range c.substrings {
+			if strings.Contains(connErr, sub) {
+				return c.reason
+			}
+		}
+	}
+	return "other"
+}
+
+func (m *checkMetrics) checkCoalesced() {
+	if m == nil {
+		return
+	}
+	m.checksCoalesced.Inc()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-check/checker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyConnectionError(t *testing.T) {
+	for connErr, reason := range map[string]string{
+		"no addresses":                                                     "no_addresses",
+		"failed to dial: no good addresses":                                "no_good_addresses",
+		"dial tcp4 1.2.3.4:4001: connect: connection refused":              "connection_refused",
+		"failed to negotiate security protocol: context deadline exceeded": "timeout",
+		"error opening relay circuit: NO_RESERVATION (204)":                "relay",
+		"peer id mismatch: expected Qm..., but remote key matches 12D3...": "peer_id_mismatch",
+		"protocols not supported: [/ipfs/bitswap/1.2.0]":                   "protocol_not_supported",
+		"something else": "other",
+	} {
+		require.Equal(t, reason, classifyConnectionError(connErr), connErr)
+	}
+}
+
+func TestCheckOutcomeMetrics(t *testing.T) {
+	m := newCheckMetrics(prometheus.NewRegistry())
+
+	m.observeCidCheck([]checker.ProviderOutput{
+		{Source: checker.SourceIPNI, ConnectionError: "dial backoff"},
+		{Source: checker.SourceDHT, DataAvailableOverBitswap: checker.BitswapCheckOutput{Found: true}},
+	})
+	m.observeCidCheck([]checker.ProviderOutput{})
+	m.observePeerCheck(&checker.PeerCheckOutput{
+		ConnectionMaddrs:         []string{"/ip4/1.2.3.4/tcp/4001/p2p/QmRelay/p2p-circuit", "/ip4/5.6.7.8/udp/4001/quic-v1"},
+		DataAvailableOverBitswap: checker.BitswapCheckOutput{Responded: true},
+	})
+	m.observePeerHealthCheck(&checker.PeerHealthOutput{ConnectionError: "connection refused"})
+	m.observeCheckError(cidCheckMode)
+
+	require.Equal(t, 1.0, testutil.ToFloat64(m.checks.WithLabelValues(cidCheckMode, outcomeFound)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.checks.WithLabelValues(cidCheckMode, outcomeNoProviders)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.checks.WithLabelValues(cidCheckMode, outcomeError)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.checks.WithLabelValues(cidMultiaddrCheckMode, outcomeNotFound)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.checks.WithLabelValues(multiaddrCheckMode, outcomeUnreachable)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.providersFound.WithLabelValues(checker.SourceIPNI)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.providersFound.WithLabelValues(checker.SourceDHT)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.connectionErrors.WithLabelValues("dial_backoff")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.connectionErrors.WithLabelValues("connection_refused")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.holePunches.WithLabelValues("success")))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-check/checker"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// crawledPeersRetention is how long a peer that wasn't seen in the latest
+// crawls is kept, so that lookups can tell when it was last seen.
+const crawledPeersRetention = 24 * time.Hour
+
+// NetworkStats is a snapshot of the Amino DHT, from the latest crawl of the
+// accelerated DHT client.
+type NetworkStats struct {
+	CrawlStarted  time.Time
+	CrawlDuration time.Duration
+	// PeersCrawled is the number of peers that responded during the crawl
+	PeersCrawled int
+	// PeersFailed is the number of peers that could not be queried during the crawl
+	PeersFailed int
+	// RoutingTableSize is the number of peers in the routing table of the accelerated DHT client
+	RoutingTableSize int
+	// AgentVersions maps agent versions, without the commit, to the number of crawled peers
+	AgentVersions map[string]int
+	// Protocols maps protocols to the number of crawled peers supporting them
+	Protocols map[string]int
+	// Transports maps transports to the number of crawled peers with an address using them
+	Transports map[string]int
+}
+
+// CrawledPeer is a peer seen while crawling the DHT
+type CrawledPeer struct {
+	ID string
+	// SeenInLastCrawl is true when the peer responded during the latest crawl
+	SeenInLastCrawl bool
+	LastSeen        *time.Time
+	AgentVersion    string
+	Protocols       []string
+	Addrs           []string
+}
+
+// networkCrawl is a crawler.Crawler recording what it finds while crawling
+// for the accelerated DHT client.
+type networkCrawl struct {
+	crawler.Crawler
+	ps peerstore.Peerstore
+
+	mu          sync.RWMutex
+	stats       *NetworkStats
+	lastCrawled time.Time
+	peers       map[peer.ID]CrawledPeer
+}
+
+var _ crawler.Crawler = (*networkCrawl)(nil)
+
+func newNetworkCrawl(c crawler.Crawler, ps peerstore.Peerstore) *networkCrawl {
+	return &networkCrawl{
+		Crawler: c,
+		ps:      ps,
+		peers:   make(map[peer.ID]CrawledPeer),
+	}
+}
+
+func (c *networkCrawl) Run(ctx context.Context, startingPeers []*peer.AddrInfo, handleSuccess crawler.HandleQueryResult, handleFail crawler.HandleQueryFail) {
+	start := time.Now()
+	var mu sync.Mutex
+	crawled := make(map[peer.ID]CrawledPeer)
+	var failed int
+
+	c.Crawler.Run(ctx, startingPeers,
+		func(p peer.ID, rtPeers []*peer.AddrInfo) {
+			handleSuccess(p, rtPeers)
+			info := c.crawledPeer(p, time.Now())
+			mu.Lock()
+			crawled[p] = info
+			mu.Unlock()
+		},
+		func(p peer.ID, err error) {
+			handleFail(p, err)
+			mu.Lock()
+			failed++
+			mu.Unlock()
+		})
+
+	if ctx.Err() != nil {
+		return
+	}
+	c.update(start, time.Since(start), crawled, failed)
+}
+
+func (c *networkCrawl) crawledPeer(p peer.ID, now time.Time) CrawledPeer {
+	out := CrawledPeer{
+		ID:       p.String(),
+		LastSeen: &now,
+	}
+	if av, err := c.ps.Get(p, "AgentVersion"); err == nil {
+		out.AgentVersion, _ = av.(string)
+	}
+	if protos, err := c.ps.GetProtocols(p); err == nil {
+		for _, proto := range protos {
+			out.Protocols = append(out.Protocols, string(proto))
+		}
+	}
+	for _, a := range c.ps.Addrs(p) {
+		out.Addrs = append(out.Addrs, a.String())
+	}
+	return out
+}
+
+// update replaces the stats with the ones of the crawl that just finished
+func (c *networkCrawl) update(start time.Time, duration time.Duration, crawled map[peer.ID]CrawledPeer, failed int) {
+	stats := &NetworkStats{
+		CrawlStarted:  start,
+		CrawlDuration: duration,
+		PeersCrawled:  len(crawled),
+		PeersFailed:   failed,
+		AgentVersions: make(map[string]int),
+		Protocols:     make(map[string]int),
+		Transports:    make(map[string]int),
+	}
+	for _, p := range crawled {
+		stats.AgentVersions[agentVersionWithoutCommit(p.AgentVersion)]++
+		for _, proto := range p.Protocols {
+			stats.Protocols[proto]++
+		}
+		for transport := range p.transports() {
+			stats.Transports[transport]++
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = stats
+	c.lastCrawled = start
+	for p, info := range crawled {
+		c.peers[p] = info
+	}
+	for p, info := range c.peers {
+		if start.Sub(*info.LastSeen) > crawledPeersRetention {
+			delete(c.peers, p)
+		}
+	}
+}
+
+// Stats returns the stats of the latest crawl, or nil if no crawl finished yet
+func (c *networkCrawl) Stats() *NetworkStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.stats == nil {
+		return nil
+	}
+	stats := *c.stats
+	return &stats
+}
+
+// Peer returns what was seen of the peer in the crawls. LastSeen is nil when
+// the peer wasn't seen in the retention period.
+func (c *networkCrawl) Peer(p peer.ID) CrawledPeer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, ok := c.peers[p]
+	if !ok {
+		return CrawledPeer{ID: p.String()}
+	}
+	// peers seen in the latest crawl were seen after it started
+	info.SeenInLastCrawl = !info.LastSeen.Before(c.lastCrawled)
+	return info
+}
+
+func (p CrawledPeer) transports() map[string]struct{} {
+	out := make(map[string]struct{})
+	for _, a := range p.Addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		out[checker.AddrTransport(ma)] = struct{}{}
+	}
+	return out
+}
+
+// agentVersionWithoutCommit strips the commit from agent versions like
+// kubo/0.29.0/3f0947b, to keep the distribution readable.
+func agentVersionWithoutCommit(av string) string {
+	if av == "" {
+		return "unknown"
+	}
+	parts := strings.SplitN(av, "/", 3)
+	if len(parts) == 3 {
+		return parts[0] + "/" + parts[1]
+	}
+	return av
+}
+
+// networkStatsHandler serves the stats of the latest crawl of the accelerated
+// DHT client.
+func networkStatsHandler(d *daemon) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+		if d.networkCrawl == nil {
+			http.Error(w, "network stats are only available with the accelerated DHT client", http.StatusNotFound)
+			return
+		}
+		stats := d.networkCrawl.Stats()
+		if stats == nil {
+			http.Error(w, "the first crawl of the DHT is still running", http.StatusServiceUnavailable)
+			return
+		}
+		stats.RoutingTableSize = d.routingTableSize()
+		w.Header().Add("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// networkPeerHandler serves whether a peer was seen while crawling the DHT
+func networkPeerHandler(d *daemon) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+		if d.networkCrawl == nil {
+			http.Error(w, "network stats are only available with the accelerated DHT client", http.StatusNotFound)
+			return
+		}
+		p, err := peer.Decode(r.PathValue("peerID"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info := d.networkCrawl.Peer(p)
+		w.Header().Add("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+	}
+}
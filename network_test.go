@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCrawler reports the given peers as crawled successfully
+type fakeCrawler struct {
+	peers []peer.ID
+}
+
+func (c *fakeCrawler) Run(ctx context.Context, startingPeers []*peer.AddrInfo, handleSuccess crawler.HandleQueryResult, handleFail crawler.HandleQueryFail) {
+	for _, p := range c.peers {
+		handleSuccess(p, nil)
+	}
+}
+
+func TestNetworkCrawl(t *testing.T) {
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	kubo, err := test.RandPeerID()
+	require.NoError(t, err)
+	require.NoError(t, ps.Put(kubo, "AgentVersion", "kubo/0.29.0/3f0947b"))
+	require.NoError(t, ps.SetProtocols(kubo, protocol.ID("/ipfs/kad/1.0.0"), protocol.ID("/ipfs/bitswap/1.2.0")))
+	ps.AddAddrs(kubo, []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1"),
+		multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"),
+	}, peerstore.PermanentAddrTTL)
+
+	other, err := test.RandPeerID()
+	require.NoError(t, err)
+	ps.AddAddrs(other, []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/5.6.7.8/tcp/4001")}, peerstore.PermanentAddrTTL)
+
+	nc := newNetworkCrawl(&fakeCrawler{peers: []peer.ID{kubo, other}}, ps)
+	require.Nil(t, nc.Stats())
+
+	var succeeded int
+	nc.Run(context.Background(), nil, func(peer.ID, []*peer.AddrInfo) { succeeded++ }, func(peer.ID, error) {})
+	require.Equal(t, 2, succeeded)
+
+	stats := nc.Stats()
+	require.NotNil(t, stats)
+	require.Equal(t, 2, stats.PeersCrawled)
+	require.Equal(t, map[string]int{"kubo/0.29.0": 1, "unknown": 1}, stats.AgentVersions)
+	require.Equal(t, map[string]int{"/ipfs/kad/1.0.0": 1, "/ipfs/bitswap/1.2.0": 1}, stats.Protocols)
+	require.Equal(t, map[string]int{"tcp": 2, "quic-v1": 1, "webtransport": 1}, stats.Transports)
+
+	seen := nc.Peer(kubo)
+	require.True(t, seen.SeenInLastCrawl)
+	require.NotNil(t, seen.LastSeen)
+	require.Equal(t, "kubo/0.29.0/3f0947b", seen.AgentVersion)
+
+	// a peer that isn't found in the next crawl is still known, but not seen in the last crawl
+	nc.Crawler = &fakeCrawler{peers: []peer.ID{other}}
+	nc.Run(context.Background(), nil, func(peer.ID, []*peer.AddrInfo) {}, func(peer.ID, error) {})
+	seen = nc.Peer(kubo)
+	require.False(t, seen.SeenInLastCrawl)
+	require.NotNil(t, seen.LastSeen)
+
+	unknown, err := test.RandPeerID()
+	require.NoError(t, err)
+	require.Nil(t, nc.Peer(unknown).LastSeen)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/routing/http/server"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-check/checker"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// routingV1Config configures the delegated routing v1 server
+type routingV1Config struct {
+	// serve /routing/v1 backed by the daemon's DHT client
+	enabled bool
+	// apply checker.DefaultProtocolFilter to requests that don't pass filter-protocols
+	filterProtocols bool
+	// publish the IPNS records of PUT requests to the DHT, which anyone can send
+	ipnsPut bool
+}
+
+// routingV1Handler returns a delegated routing v1 server backed by the
+// daemon's DHT client, see https://specs.ipfs.tech/routing/http-routing-v1/
+func routingV1Handler(d *daemon, cfg routingV1Config) http.Handler {
+	handler := server.Handler(&dhtContentRouter{d: d, ipnsPut: cfg.ipnsPut})
+	defaultFilter := strings.Join(checker.DefaultProtocolFilter, ",")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Allow browser clients to use us as their delegated router
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+
+		// IPIP-484 filtering is applied by the server, so set the default filter when none was requested
+		query := r.URL.Query()
+		if cfg.filterProtocols && !query.Has("filter-protocols") {
+			query.Set("filter-protocols", defaultFilter)
+			r.URL.RawQuery = query.Encode()
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// dhtContentRouter implements the delegated routing v1 server on top of the
+// daemon's DHT client.
+type dhtContentRouter struct {
+	d       *daemon
+	ipnsPut bool
+}
+
+var _ server.ContentRouter = (*dhtContentRouter)(nil)
+
+func (r *dhtContentRouter) FindProviders(ctx context.Context, c cid.Cid, limit int) (iter.ResultIter[types.Record], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	provsCh := r.d.dht.FindProvidersAsync(ctx, c, limit)
+	return &providersIter{ch: provsCh, cancel: cancel, router: r}, nil
+}
+
+func (r *dhtContentRouter) FindPeers(ctx context.Context, pid peer.ID, limit int) (iter.ResultIter[*types.PeerRecord], error) {
+	ai, err := r.d.dht.FindPeer(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+	return iter.ToResultIter[*types.PeerRecord](iter.FromSlice([]*types.PeerRecord{r.peerRecord(ai)})), nil
+}
+
+func (r *dhtContentRouter) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	raw, err := r.d.dht.GetValue(ctx, string(name.RoutingKey()))
+	if err != nil {
+		return nil, err
+	}
+	return ipns.UnmarshalRecord(raw)
+}
+
+func (r *dhtContentRouter) PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Record) error {
+	if !r.ipnsPut {
+		return routing.ErrNotSupported
+	}
+	raw, err := ipns.MarshalRecord(record)
+	if err != nil {
+		return err
+	}
+	return r.d.dht.PutValue(ctx, string(name.RoutingKey()), raw)
+}
+
+func (r *dhtContentRouter) ProvideBitswap(ctx context.Context, req *server.BitswapWriteProvideRequest) (time.Duration, error) {
+	return 0, routing.ErrNotSupported
+}
+
+// peerRecord converts the addresses of a peer found in the DHT to a peer
+// record. The DHT doesn't tell which transports the peer supports, so
+// transport-bitswap is only set when it was learnt through identify.
+func (r *dhtContentRouter) peerRecord(ai peer.AddrInfo) *types.PeerRecord {
+	rec := &types.PeerRecord{
+		Schema: types.SchemaPeer,
+		ID:     &ai.ID,
+	}
+	for _, addr := range ai.Addrs {
+		rec.Addrs = append(rec.Addrs, types.Multiaddr{Multiaddr: addr})
+	}
+	if protos, err := r.d.h.Peerstore().SupportsProtocols(ai.ID, checker.BitswapProtocols...); err == nil && len(protos) > 0 {
+		rec.Protocols = []string{checker.ProtocolBitswap}
+	}
+	return rec
+}
+
+// providersIter is a result iterator over the providers returned by
+// FindProvidersAsync. Closing it cancels the underlying query.
+type providersIter struct {
+	ch     <-chan peer.AddrInfo
+	cancel context.CancelFunc
+	router *dhtContentRouter
+	val    iter.Result[types.Record]
+}
+
+func (it *providersIter) Next() bool {
+	ai, ok := <-it.ch
+	if !ok {
+		return false
+	}
+	it.val = iter.Result[types.Record]{Val: it.router.peerRecord(ai)}
+	return true
+}
+
+func (it *providersIter) Val() iter.Result[types.Record] {
+	return it.val
+}
+
+func (it *providersIter) Close() error {
+	it.cancel()
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingV1PutIPNSDisabled(t *testing.T) {
+	// the record isn't even looked at, as nothing is published by default
+	r := &dhtContentRouter{d: &daemon{}}
+	err := r.PutIPNS(context.Background(), ipns.Name{}, nil)
+	require.ErrorIs(t, err, routing.ErrNotSupported)
+}
@@ -28,6 +28,17 @@ func Query(
 	url string,
 	cid string,
 	multiaddr string,
+) *httpexpect.Object {
+	return QueryWithParams(t, url, cid, multiaddr, nil)
+}
+
+// QueryWithParams is like Query, with additional query parameters for the check
+func QueryWithParams(
+	t *testing.T,
+	url string,
+	cid string,
+	multiaddr string,
+	params map[string]string,
 ) *httpexpect.Object {
 	expectedContentType := "application/json"
 	if url == "https://ipfs-check-backend.ipfs.io" {
@@ -43,10 +54,14 @@ func Query(
 
 	e := httpexpect.Default(t, url)
 
-	return e.POST("/check").
+	req := e.POST("/check").
 		WithQuery("cid", cid).
-		WithQuery("multiaddr", multiaddr).
-		Expect().
+		WithQuery("multiaddr", multiaddr)
+	for k, v := range params {
+		req = req.WithQuery(k, v)
+	}
+
+	return req.Expect().
 		Status(http.StatusOK).
 		JSON(opts).Object()
 }
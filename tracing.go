@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ipfs/boxo/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// setupTracing sets the global tracer provider, exporting spans as configured
+// by the standard OTEL_* env vars, e.g. OTEL_TRACES_EXPORTER=otlp. It returns
+// a function flushing the spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporters, err := tracing.NewSpanExporters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(name),
+			semconv.ServiceVersion(version),
+		)),
+	}
+	for _, exporter := range exporters {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// tracingHandler starts a span for each request, continuing the trace of the
+// traceparent header if any. Metrics and health probes aren't traced.
+func tracingHandler(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, name, otelhttp.WithFilter(func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/metrics", "/healthz", "/readyz":
+			return false
+		}
+		return true
+	}))
+}